@@ -38,7 +38,9 @@ type MandrillAPI struct {
 	Key       string
 	Transport http.RoundTripper
 	Timeout   time.Duration
-	endpoint  string
+	// TemplateHistory, when set, records every template change made through this client
+	TemplateHistory TemplateHistoryStore
+	endpoint        string
 }
 
 type ChimpAPI struct {
@@ -25,7 +25,7 @@ func (a *ChimpAPI) InlineCSS(req InlineCSSRequest) (InlineCSSResponse, error) {
 type InlineCSSRequest struct {
 	ApiKey   string `json:"apikey"`
 	HTML     string `json:"html"`
-	StripCSS bool   `json:"strip_html"`
+	StripCSS bool   `json:"strip_css"`
 }
 
 type InlineCSSResponse struct {
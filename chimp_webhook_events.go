@@ -0,0 +1,220 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// see http://apidocs.mailchimp.com/webhooks/
+
+// the list webhook event types, the values of ChimpWebhookEvent.Type, one
+// for each of the ChimpWebhookActions
+const (
+	ChimpWebhookSubscribe   = "subscribe"
+	ChimpWebhookUnsubscribe = "unsubscribe"
+	ChimpWebhookProfile     = "profile"
+	ChimpWebhookCleaned     = "cleaned"
+	ChimpWebhookUpemail     = "upemail"
+	ChimpWebhookCampaign    = "campaign"
+)
+
+// ChimpWebhookEvent is a list webhook post. MailChimp posts one event at a
+// time, form encoded, with its details under data[...] keys.
+type ChimpWebhookEvent struct {
+	Type    string           `json:"type"`
+	FiredAt APITime          `json:"fired_at"`
+	Data    ChimpWebhookData `json:"data"`
+	// Raw is the form as it was posted
+	Raw url.Values `json:"-"`
+}
+
+// ChimpWebhookData holds the details of an event, the fields set depend on its type.
+type ChimpWebhookData struct {
+	// Id is the member id, or the campaign id of campaign events
+	Id     string `json:"id"`
+	ListId string `json:"list_id"`
+	Email  string `json:"email"`
+	// EmailType is html or text
+	EmailType string `json:"email_type"`
+	// Merges are the merge vars of subscribe, unsubscribe and profile events,
+	// nested values such as GROUPINGS are maps or slices
+	Merges   map[string]interface{} `json:"merges"`
+	IpOpt    string                 `json:"ip_opt"`
+	IpSignup string                 `json:"ip_signup"`
+
+	// unsubscribe and cleaned events, Action is unsub or delete, Reason is
+	// manual or abuse for unsubscribes and hard or abuse for cleaned addresses
+	Action     string `json:"action"`
+	Reason     string `json:"reason"`
+	CampaignId string `json:"campaign_id"`
+
+	// upemail events
+	NewId    string `json:"new_id"`
+	NewEmail string `json:"new_email"`
+	OldEmail string `json:"old_email"`
+
+	// campaign events, Status is sent
+	Subject string `json:"subject"`
+	Status  string `json:"status"`
+}
+
+// ChimpWebhookGrouping is an interest grouping of the merges of an event.
+type ChimpWebhookGrouping struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Groups are the groups of the grouping the member is in, comma separated
+	Groups string `json:"groups"`
+}
+
+// Merge returns a merge var of the event, blank when it is missing or not a string.
+func (d ChimpWebhookData) Merge(name string) string {
+	s, _ := d.Merges[strings.ToUpper(name)].(string)
+	return s
+}
+
+// Groupings returns the interest groupings of the merges of the event.
+func (d ChimpWebhookData) Groupings() []ChimpWebhookGrouping {
+	data, err := json.Marshal(d.Merges["GROUPINGS"])
+	if err != nil {
+		return nil
+	}
+	var groupings []ChimpWebhookGrouping
+	json.Unmarshal(data, &groupings)
+	return groupings
+}
+
+// ParseChimpWebhookEvent decodes the form of a list webhook post.
+func ParseChimpWebhookEvent(form url.Values) (ChimpWebhookEvent, error) {
+	var event ChimpWebhookEvent
+	if form.Get("type") == "" {
+		return event, errors.New("type cannot be blank")
+	}
+	decoded, err := DecodeBracketForm(form)
+	if err != nil {
+		return event, err
+	}
+	// the form is decoded through json, so that the struct tags apply
+	data, err := json.Marshal(decoded)
+	if err != nil {
+		return event, err
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return event, err
+	}
+	event.Raw = form
+	return event, nil
+}
+
+// DecodeBracketForm decodes form values whose keys are bracketed paths, such
+// as data[merges][FNAME], into nested maps. Maps whose keys are all indexes,
+// such as data[merges][GROUPINGS][0], become slices, and an empty bracket
+// appends to a slice. It errors when a key is both a value and a path prefix.
+func DecodeBracketForm(form url.Values) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(form))
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	root := make(map[string]interface{})
+	for _, key := range keys {
+		path, err := splitBracketKey(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range form[key] {
+			if err := setBracketValue(root, path, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+	}
+	for key, child := range root {
+		root[key] = bracketSlices(child)
+	}
+	return root, nil
+}
+
+// splitBracketKey splits a[b][c] into a, b, c.
+func splitBracketKey(key string) ([]string, error) {
+	open := strings.IndexByte(key, '[')
+	if open < 0 {
+		return []string{key}, nil
+	}
+	path := []string{key[:open]}
+	rest := key[open:]
+	for rest != "" {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end < 0 {
+			return nil, fmt.Errorf("malformed form key %q", key)
+		}
+		path = append(path, rest[1:end])
+		rest = rest[end+1:]
+	}
+	return path, nil
+}
+
+func setBracketValue(node map[string]interface{}, path []string, value string) error {
+	for i, name := range path {
+		if name == "" {
+			// a[] appends, name it after the number of values so far
+			name = strconv.Itoa(len(node))
+		}
+		if i == len(path)-1 {
+			if _, isMap := node[name].(map[string]interface{}); isMap {
+				return errors.New("value conflicts with nested keys")
+			}
+			node[name] = value
+			return nil
+		}
+		switch child := node[name].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			node[name] = next
+			node = next
+		case map[string]interface{}:
+			node = child
+		default:
+			return errors.New("nested keys conflict with a value")
+		}
+	}
+	return nil
+}
+
+// bracketSlices turns the maps keyed by indexes into slices, in index order.
+func bracketSlices(value interface{}) interface{} {
+	node, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	indexes := make([]int, 0, len(node))
+	for key, child := range node {
+		node[key] = bracketSlices(child)
+		if index, err := strconv.Atoi(key); err == nil && index >= 0 && strconv.Itoa(index) == key {
+			indexes = append(indexes, index)
+		}
+	}
+	if len(node) == 0 || len(indexes) != len(node) {
+		return node
+	}
+	sort.Ints(indexes)
+	slice := make([]interface{}, len(indexes))
+	for i, index := range indexes {
+		slice[i] = node[strconv.Itoa(index)]
+	}
+	return slice
+}
@@ -0,0 +1,85 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// chimpSubscribe is a subscribe post, as MailChimp documents it
+const chimpSubscribe = "type=subscribe&fired_at=2009-03-26+21%3A35%3A57" +
+	"&data%5Bid%5D=8a25ff1d98&data%5Blist_id%5D=a6b5da1054" +
+	"&data%5Bemail%5D=api%40mailchimp.com&data%5Bemail_type%5D=html" +
+	"&data%5Bmerges%5D%5BEMAIL%5D=api%40mailchimp.com&data%5Bmerges%5D%5BFNAME%5D=MailChimp" +
+	"&data%5Bmerges%5D%5BLNAME%5D=API&data%5Bmerges%5D%5BINTERESTS%5D=Group1%2CGroup2" +
+	"&data%5Bmerges%5D%5BGROUPINGS%5D%5B1%5D%5Bid%5D=7&data%5Bmerges%5D%5BGROUPINGS%5D%5B1%5D%5Bname%5D=Colors" +
+	"&data%5Bmerges%5D%5BGROUPINGS%5D%5B1%5D%5Bgroups%5D=Blue" +
+	"&data%5Bmerges%5D%5BGROUPINGS%5D%5B0%5D%5Bid%5D=1&data%5Bmerges%5D%5BGROUPINGS%5D%5B0%5D%5Bname%5D=Interests" +
+	"&data%5Bmerges%5D%5BGROUPINGS%5D%5B0%5D%5Bgroups%5D=Group1%2CGroup2" +
+	"&data%5Bip_opt%5D=10.20.10.30&data%5Bip_signup%5D=10.20.10.30"
+
+func TestParseChimpWebhookEvent(t *testing.T) {
+	form, err := url.ParseQuery(chimpSubscribe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := ParseChimpWebhookEvent(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != ChimpWebhookSubscribe || !event.FiredAt.Equal(time.Date(2009, 3, 26, 21, 35, 57, 0, time.UTC)) {
+		t.Errorf("wrong event %+v", event)
+	}
+	if event.Data.Id != "8a25ff1d98" || event.Data.ListId != "a6b5da1054" || event.Data.Email != "api@mailchimp.com" || event.Data.IpOpt != "10.20.10.30" {
+		t.Errorf("wrong data %+v", event.Data)
+	}
+	if event.Data.Merge("fname") != "MailChimp" || event.Data.Merge("INTERESTS") != "Group1,Group2" {
+		t.Errorf("wrong merges %v", event.Data.Merges)
+	}
+	want := []ChimpWebhookGrouping{{Id: "1", Name: "Interests", Groups: "Group1,Group2"}, {Id: "7", Name: "Colors", Groups: "Blue"}}
+	if groupings := event.Data.Groupings(); !reflect.DeepEqual(groupings, want) {
+		t.Errorf("wrong groupings %+v", groupings)
+	}
+
+	upemail := url.Values{"type": {"upemail"}, "data[list_id]": {"a6b5da1054"}, "data[new_id]": {"51da8c3259"},
+		"data[new_email]": {"new@mailchimp.com"}, "data[old_email]": {"old@mailchimp.com"}}
+	event, err = ParseChimpWebhookEvent(upemail)
+	if err != nil || event.Data.NewEmail != "new@mailchimp.com" || event.Data.OldEmail != "old@mailchimp.com" {
+		t.Errorf("wrong upemail %+v %v", event, err)
+	}
+	if _, err := ParseChimpWebhookEvent(url.Values{"data[email]": {"a@b.c"}}); err == nil {
+		t.Error("expected an error without type")
+	}
+}
+
+func TestDecodeBracketForm(t *testing.T) {
+	decoded, err := DecodeBracketForm(url.Values{"a": {"1"}, "b[]": {"x", "y"}, "c[d][0]": {"z"}, "c[e]": {"w"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a": "1",
+		"b": []interface{}{"x", "y"},
+		"c": map[string]interface{}{"d": []interface{}{"z"}, "e": "w"},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("got %#v", decoded)
+	}
+	for _, form := range []url.Values{{"a": {"1"}, "a[b]": {"2"}}, {"a[b": {"1"}}, {"a[b]c": {"1"}}} {
+		if _, err := DecodeBracketForm(form); err == nil {
+			t.Errorf("expected an error for %v", form)
+		}
+	}
+}
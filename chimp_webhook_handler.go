@@ -0,0 +1,156 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ChimpWebhookSecretParam is the query param a ChimpWebhookHandler reads its
+// secret from when SecretParam is not set.
+const ChimpWebhookSecretParam = "secret"
+
+// ErrInvalidSecret is returned for list webhook requests without the right secret.
+var ErrInvalidSecret = errors.New("invalid webhook secret")
+
+// ChimpWebhookFunc handles one list webhook event. Returning an error makes
+// the handler answer 500.
+type ChimpWebhookFunc func(event ChimpWebhookEvent) error
+
+// ChimpWebhookHandler is an http.Handler receiving MailChimp list webhook
+// posts. MailChimp does not sign its posts, so the handler authenticates them
+// with a secret token in the query of the webhook url, see ChimpWebhookURL.
+// It answers the GET request MailChimp validates new webhooks with and calls
+// the callbacks registered for the event type.
+//
+// Callbacks must be registered before the handler serves requests.
+type ChimpWebhookHandler struct {
+	// Secret is the token requests must carry, requests are refused when it is blank
+	Secret string
+	// SkipVerification accepts requests without checking their secret
+	SkipVerification bool
+	// SecretParam is the query param carrying Secret, blank means ChimpWebhookSecretParam
+	SecretParam string
+
+	handlers map[string][]ChimpWebhookFunc
+	any      []ChimpWebhookFunc
+}
+
+// NewChimpWebhookHandler returns a handler accepting the requests carrying secret.
+func NewChimpWebhookHandler(secret string) *ChimpWebhookHandler {
+	return &ChimpWebhookHandler{Secret: secret}
+}
+
+// ChimpWebhookURL adds a secret to the query of a webhook url, the url to give
+// WebhookAdd for a ChimpWebhookHandler. param blank means ChimpWebhookSecretParam.
+func ChimpWebhookURL(webhookURL string, param string, secret string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", err
+	}
+	if param == "" {
+		param = ChimpWebhookSecretParam
+	}
+	query := u.Query()
+	query.Set(param, secret)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// On registers fn for the events of a type, one of the ChimpWebhook constants.
+func (h *ChimpWebhookHandler) On(eventType string, fn ChimpWebhookFunc) {
+	if h.handlers == nil {
+		h.handlers = make(map[string][]ChimpWebhookFunc)
+	}
+	h.handlers[eventType] = append(h.handlers[eventType], fn)
+}
+
+// OnAny registers fn for every event.
+func (h *ChimpWebhookHandler) OnAny(fn ChimpWebhookFunc) {
+	h.any = append(h.any, fn)
+}
+
+func (h *ChimpWebhookHandler) OnSubscribe(fn ChimpWebhookFunc)   { h.On(ChimpWebhookSubscribe, fn) }
+func (h *ChimpWebhookHandler) OnUnsubscribe(fn ChimpWebhookFunc) { h.On(ChimpWebhookUnsubscribe, fn) }
+func (h *ChimpWebhookHandler) OnProfile(fn ChimpWebhookFunc)     { h.On(ChimpWebhookProfile, fn) }
+func (h *ChimpWebhookHandler) OnCleaned(fn ChimpWebhookFunc)     { h.On(ChimpWebhookCleaned, fn) }
+func (h *ChimpWebhookHandler) OnUpemail(fn ChimpWebhookFunc)     { h.On(ChimpWebhookUpemail, fn) }
+func (h *ChimpWebhookHandler) OnCampaign(fn ChimpWebhookFunc)    { h.On(ChimpWebhookCampaign, fn) }
+
+func (h *ChimpWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		http.Error(w, ErrInvalidSecret.Error(), http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		// MailChimp checks the url answers when the webhook is added
+		w.WriteHeader(http.StatusOK)
+		return
+	case "POST":
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	event, err := ParseChimpWebhookEvent(r.PostForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.Dispatch(event); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *ChimpWebhookHandler) authorized(r *http.Request) bool {
+	if h.SkipVerification {
+		return true
+	}
+	if h.Secret == "" {
+		// a handler missing its secret must not take posts from anyone
+		return false
+	}
+	param := h.SecretParam
+	if param == "" {
+		param = ChimpWebhookSecretParam
+	}
+	secret := r.URL.Query().Get(param)
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(h.Secret)) == 1
+}
+
+// Dispatch calls the callbacks registered for an event, in the order they were
+// registered, stopping at the first that fails or panics.
+func (h *ChimpWebhookHandler) Dispatch(event ChimpWebhookEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s event: panic: %v", event.Type, r)
+		}
+	}()
+	fns := append(append([]ChimpWebhookFunc(nil), h.handlers[event.Type]...), h.any...)
+	for _, fn := range fns {
+		if err := fn(event); err != nil {
+			return fmt.Errorf("%s event: %v", event.Type, err)
+		}
+	}
+	return nil
+}
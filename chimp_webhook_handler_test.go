@@ -0,0 +1,95 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postChimpWebhook(h http.Handler, webhookURL string, form string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", webhookURL, strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestChimpWebhookHandler(t *testing.T) {
+	webhookURL, err := ChimpWebhookURL("https://example.com/mailchimp?list=1", "", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhookURL != "https://example.com/mailchimp?list=1&secret=s3cret" {
+		t.Errorf("wrong url %s", webhookURL)
+	}
+	h := NewChimpWebhookHandler("s3cret")
+	var seen []string
+	h.OnSubscribe(func(event ChimpWebhookEvent) error {
+		seen = append(seen, event.Data.Merge("FNAME"))
+		return nil
+	})
+	h.OnAny(func(event ChimpWebhookEvent) error {
+		seen = append(seen, event.Type)
+		return nil
+	})
+	h.OnCleaned(func(event ChimpWebhookEvent) error {
+		return errors.New("store down")
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", webhookURL, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET got status %d", w.Code)
+	}
+	if w := postChimpWebhook(h, webhookURL, chimpSubscribe); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if w := postChimpWebhook(h, webhookURL, "type=profile&data%5Bemail%5D=a%40b.c"); w.Code != http.StatusOK {
+		t.Errorf("profile got status %d", w.Code)
+	}
+	if strings.Join(seen, ",") != "MailChimp,subscribe,profile" {
+		t.Errorf("wrong dispatch %v", seen)
+	}
+	if w := postChimpWebhook(h, webhookURL, "type=cleaned&data%5Breason%5D=hard"); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "store down") {
+		t.Errorf("failing callback got status %d: %s", w.Code, w.Body)
+	}
+	if w := postChimpWebhook(h, "https://example.com/mailchimp?secret=wrong", chimpSubscribe); w.Code != http.StatusForbidden {
+		t.Errorf("wrong secret got status %d", w.Code)
+	}
+	if w := postChimpWebhook(h, webhookURL, "data%5Bemail%5D=a%40b.c"); w.Code != http.StatusBadRequest {
+		t.Errorf("missing type got status %d", w.Code)
+	}
+}
+
+func TestChimpWebhookHandlerWithoutSecret(t *testing.T) {
+	const webhookURL = "https://example.com/mailchimp"
+	dispatched := 0
+	h := NewChimpWebhookHandler("")
+	h.OnAny(func(event ChimpWebhookEvent) error {
+		dispatched++
+		return nil
+	})
+	// even a url carrying an empty secret is refused
+	for _, u := range []string{webhookURL, webhookURL + "?secret="} {
+		if w := postChimpWebhook(h, u, chimpSubscribe); w.Code != http.StatusForbidden || dispatched != 0 {
+			t.Errorf("a handler without secret got status %d for %s", w.Code, u)
+		}
+	}
+	h.SkipVerification = true
+	if w := postChimpWebhook(h, webhookURL, chimpSubscribe); w.Code != http.StatusOK || dispatched != 1 {
+		t.Errorf("got status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PlanChimpWebhookSync compares the wanted webhooks of a list to the ones
+// returned by Webhooks. MailChimp cannot update a webhook, so updates delete
+// it and add it again: when the add fails after the delete, the list is left
+// without that webhook until the sync runs again.
+func PlanChimpWebhookSync(listId string, wanted []ChimpWebhook, remote []ChimpWebhook, opts WebhookSyncOptions) WebhookSyncPlan {
+	var plan WebhookSyncPlan
+	byUrl := make(map[string]ChimpWebhook, len(remote))
+	for _, webhook := range remote {
+		byUrl[webhook.Url] = webhook
+	}
+	wantedUrls := make(map[string]bool, len(wanted))
+	for i := range wanted {
+		w := wanted[i]
+		wantedUrls[w.Url] = true
+		r, found := byUrl[w.Url]
+		if !found {
+			plan.Actions = append(plan.Actions, WebhookSyncAction{Op: WebhookSyncCreate, ListId: listId, Url: w.Url, Chimp: &w})
+			continue
+		}
+		var changes []string
+		if w.Actions != r.Actions {
+			changes = append(changes, "actions")
+		}
+		if w.Sources != r.Sources {
+			changes = append(changes, "sources")
+		}
+		if len(changes) > 0 {
+			plan.Actions = append(plan.Actions, WebhookSyncAction{Op: WebhookSyncUpdate, ListId: listId, Url: w.Url, Changes: changes, Chimp: &w})
+			continue
+		}
+		plan.Unchanged = append(plan.Unchanged, w.Url)
+	}
+	if opts.DeleteOrphans {
+		var orphans []string
+		for _, r := range remote {
+			if !wantedUrls[r.Url] {
+				orphans = append(orphans, r.Url)
+			}
+		}
+		sort.Strings(orphans)
+		for _, url := range orphans {
+			plan.Actions = append(plan.Actions, WebhookSyncAction{Op: WebhookSyncDelete, ListId: listId, Url: url})
+		}
+	}
+	return plan
+}
+
+// EnsureWebhooks brings the webhooks of lists in line with the wanted ones,
+// keyed by list id. Lists that are not keys are left alone. The plan is
+// returned even when it was only partly applied.
+func (a *ChimpAPI) EnsureWebhooks(wanted map[string][]ChimpWebhook, opts WebhookSyncOptions) (WebhookSyncPlan, error) {
+	listIds := make([]string, 0, len(wanted))
+	for listId := range wanted {
+		listIds = append(listIds, listId)
+	}
+	sort.Strings(listIds)
+	var plan WebhookSyncPlan
+	for _, listId := range listIds {
+		remote, err := a.Webhooks(ChimpWebhooksRequest{ListId: listId})
+		if err != nil {
+			return plan, err
+		}
+		listPlan := PlanChimpWebhookSync(listId, wanted[listId], remote, opts)
+		plan.Actions = append(plan.Actions, listPlan.Actions...)
+		plan.Unchanged = append(plan.Unchanged, listPlan.Unchanged...)
+	}
+	if opts.DryRun {
+		return plan, nil
+	}
+	return plan, a.WebhookSyncApply(plan)
+}
+
+// WebhookSyncApply performs every action of a plan in order, stopping at the
+// first error.
+func (a *ChimpAPI) WebhookSyncApply(plan WebhookSyncPlan) error {
+	for _, action := range plan.Actions {
+		var err error
+		switch action.Op {
+		case WebhookSyncCreate:
+			_, err = a.WebhookAdd(ChimpWebhookAddRequest{ChimpWebhook: *action.Chimp, ListId: action.ListId})
+		case WebhookSyncUpdate:
+			_, err = a.WebhookDel(ChimpWebhookDelRequest{ListId: action.ListId, Url: action.Url})
+			if err == nil {
+				_, err = a.WebhookAdd(ChimpWebhookAddRequest{ChimpWebhook: *action.Chimp, ListId: action.ListId})
+			}
+		case WebhookSyncDelete:
+			_, err = a.WebhookDel(ChimpWebhookDelRequest{ListId: action.ListId, Url: action.Url})
+		default:
+			err = fmt.Errorf("unknown webhook sync operation %q", action.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", action, err)
+		}
+	}
+	return nil
+}
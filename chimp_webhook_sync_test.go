@@ -0,0 +1,38 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import "testing"
+
+func TestPlanChimpWebhookSync(t *testing.T) {
+	remote := []ChimpWebhook{
+		{Url: "https://example.com/list", Actions: ChimpWebhookActions{Subscribe: true}, Sources: ChimpWebhookSources{User: true}},
+		{Url: "https://example.com/same", Actions: ChimpWebhookActions{Cleaned: true}},
+		{Url: "https://example.com/old"},
+	}
+	wanted := []ChimpWebhook{
+		{Url: "https://example.com/list", Actions: ChimpWebhookActions{Subscribe: true, Unsubscribe: true}, Sources: ChimpWebhookSources{User: true}},
+		{Url: "https://example.com/same", Actions: ChimpWebhookActions{Cleaned: true}},
+		{Url: "https://example.com/new", Sources: ChimpWebhookSources{Api: true}},
+	}
+	plan := PlanChimpWebhookSync("a1b2", wanted, remote, WebhookSyncOptions{})
+	expected := "update https://example.com/list on list a1b2 (actions)\n" +
+		"create https://example.com/new on list a1b2\n" +
+		"2 to change, 1 unchanged\n"
+	if plan.String() != expected {
+		t.Errorf("wrong plan\n%s", plan)
+	}
+	plan = PlanChimpWebhookSync("a1b2", wanted, remote, WebhookSyncOptions{DeleteOrphans: true})
+	if last := plan.Actions[len(plan.Actions)-1]; last.Op != WebhookSyncDelete || last.Url != "https://example.com/old" {
+		t.Errorf("wrong orphan %v", last)
+	}
+}
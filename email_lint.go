@@ -0,0 +1,457 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// GmailClipSize is the size of html over which Gmail clips a message behind
+// a "View entire message" link.
+const GmailClipSize = 102 * 1024
+
+// the rules of LintEmailHTML, they can be turned off with LintOptions.Disable
+const (
+	LintImageAlt        = "img-alt"
+	LintImageDimensions = "img-dimensions"
+	LintUnsupportedCSS  = "css-support"
+	LintGmailClipping   = "gmail-clip"
+	LintMergeBlocks     = "merge-if"
+	LintUnsubscribe     = "unsubscribe"
+	LintEditRegions     = "mc-edit"
+	LintMarkup          = "markup"
+)
+
+// the severities of a LintDiagnostic
+const (
+	LintSeverityError   = "error"
+	LintSeverityWarning = "warning"
+)
+
+// LintOptions controls LintEmailHTML.
+type LintOptions struct {
+	// Marketing content must carry an unsubscribe merge tag
+	Marketing bool
+	// MergeLanguage is the merge language of the content, blank meaning mailchimp
+	MergeLanguage string
+	// MaxSize is the size over which the html is reported, 0 means GmailClipSize
+	MaxSize int
+	// Disable lists the rules to skip
+	Disable []string
+}
+
+// LintDiagnostic is one problem found in email html.
+type LintDiagnostic struct {
+	Rule     string
+	Severity string
+	Message  string
+	// Offset is the byte offset of the problem, Line and Column its 1 based
+	// position, the column counted in characters
+	Offset int
+	Line   int
+	Column int
+	// Region is the mc:edit section the problem is in, for LintCampaignContent
+	Region string
+}
+
+func (d LintDiagnostic) String() string {
+	where := fmt.Sprintf("%d:%d", d.Line, d.Column)
+	if d.Region != "" {
+		where = d.Region + ":" + where
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", where, d.Severity, d.Message, d.Rule)
+}
+
+// LintErrors returns the diagnostics with an error severity.
+func LintErrors(diagnostics []LintDiagnostic) []LintDiagnostic {
+	var errs []LintDiagnostic
+	for _, d := range diagnostics {
+		if d.Severity == LintSeverityError {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+// LintEmailHTML checks email html, a template or the content of a campaign,
+// for what commonly breaks in email clients: images without alt text or
+// dimensions, CSS that common clients ignore, html Gmail clips, unbalanced
+// merge tag conditionals, a missing unsubscribe tag in marketing content and
+// broken mc:edit markup. The diagnostics are sorted by position.
+func LintEmailHTML(source string, opts LintOptions) []LintDiagnostic {
+	l := &emailLinter{source: source, opts: opts}
+	doc := parseHTML(source)
+	l.markup(doc)
+	l.images(doc)
+	l.css(doc)
+	l.size()
+	l.mergeBlocks()
+	l.unsubscribe()
+	l.editRegions(doc)
+	sort.SliceStable(l.diagnostics, func(i, j int) bool { return l.diagnostics[i].Offset < l.diagnostics[j].Offset })
+	return l.diagnostics
+}
+
+// LintCampaignContent lints the html and each section of campaign content as
+// marketing content. The unsubscribe tag and the size are checked on the
+// content as a whole.
+func LintCampaignContent(content CampaignCreateContent, opts LintOptions) []LintDiagnostic {
+	opts.Marketing = true
+	var diagnostics []LintDiagnostic
+	if content.HTML != "" {
+		diagnostics = append(diagnostics, LintEmailHTML(content.HTML, opts)...)
+	}
+	names := make([]string, 0, len(content.Sections))
+	for name := range content.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sectionOpts := opts
+	sectionOpts.Marketing = false
+	sectionOpts.Disable = append(append([]string(nil), opts.Disable...), LintGmailClipping)
+	total := len(content.HTML)
+	all := content.HTML
+	for _, name := range names {
+		for _, d := range LintEmailHTML(content.Sections[name], sectionOpts) {
+			d.Region = name
+			diagnostics = append(diagnostics, d)
+		}
+		total += len(content.Sections[name])
+		all += content.Sections[name]
+	}
+	if content.HTML == "" {
+		l := &emailLinter{source: all, opts: opts}
+		l.unsubscribe()
+		if total > l.maxSize() && l.enabled(LintGmailClipping) {
+			l.report(LintGmailClipping, LintSeverityWarning, 0, "content is %s, Gmail clips messages over %s", formatSize(total), formatSize(l.maxSize()))
+		}
+		diagnostics = append(diagnostics, l.diagnostics...)
+	}
+	return diagnostics
+}
+
+// LintTemplate fetches a template with TemplateInfo and lints its code.
+func (a *MandrillAPI) LintTemplate(name string, opts LintOptions) ([]LintDiagnostic, error) {
+	template, err := a.TemplateInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	return LintEmailHTML(template.Code, opts), nil
+}
+
+type emailLinter struct {
+	source      string
+	opts        LintOptions
+	diagnostics []LintDiagnostic
+}
+
+func (l *emailLinter) enabled(rule string) bool {
+	return !hasString(l.opts.Disable, rule)
+}
+
+func (l *emailLinter) report(rule string, severity string, offset int, format string, args ...interface{}) {
+	if !l.enabled(rule) {
+		return
+	}
+	if offset > len(l.source) {
+		offset = len(l.source)
+	}
+	lineStart := strings.LastIndexByte(l.source[:offset], '\n') + 1
+	l.diagnostics = append(l.diagnostics, LintDiagnostic{
+		Rule:     rule,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		Offset:   offset,
+		Line:     strings.Count(l.source[:offset], "\n") + 1,
+		Column:   utf8.RuneCountInString(l.source[lineStart:offset]) + 1,
+	})
+}
+
+func (l *emailLinter) maxSize() int {
+	if l.opts.MaxSize > 0 {
+		return l.opts.MaxSize
+	}
+	return GmailClipSize
+}
+
+func (l *emailLinter) markup(doc *htmlDocument) {
+	for _, issue := range doc.issues {
+		l.report(LintMarkup, LintSeverityWarning, issue.offset, "%s", issue.message)
+	}
+}
+
+func (l *emailLinter) images(doc *htmlDocument) {
+	doc.root.walk(func(n *htmlNode) {
+		if n.kind != htmlElementNode || n.tag != "img" {
+			return
+		}
+		if _, found := n.attr("alt"); !found {
+			l.report(LintImageAlt, LintSeverityWarning, n.start, `img has no alt attribute, use alt="" for decorative images`)
+		}
+		var missing []string
+		for _, name := range []string{"width", "height"} {
+			if _, found := n.attr(name); !found {
+				missing = append(missing, name)
+			}
+		}
+		switch len(missing) {
+		case 2:
+			l.report(LintImageDimensions, LintSeverityWarning, n.start, "img has no width and height attributes, Outlook shows it at its natural size")
+		case 1:
+			if missing[0] == "width" {
+				l.report(LintImageDimensions, LintSeverityWarning, n.start, "img has no width attribute, Outlook ignores a css width")
+			}
+		}
+	})
+}
+
+// lintUnsupportedCSS lists css properties and the common clients ignoring them.
+var lintUnsupportedCSS = map[string][]string{
+	"position":         {"Gmail", "Outlook"},
+	"float":            {"Outlook"},
+	"background-image": {"Outlook"},
+	"box-shadow":       {"Outlook"},
+	"text-shadow":      {"Outlook"},
+	"border-radius":    {"Outlook"},
+	"max-width":        {"Outlook"},
+	"min-width":        {"Outlook"},
+	"min-height":       {"Outlook"},
+	"opacity":          {"Outlook"},
+	"transform":        {"Gmail", "Outlook"},
+	"transition":       {"Gmail", "Outlook"},
+	"animation":        {"Gmail", "Outlook"},
+	"object-fit":       {"Gmail", "Outlook"},
+	"clip-path":        {"Gmail", "Outlook"},
+	"filter":           {"Gmail", "Outlook"},
+	"grid-template":    {"Gmail", "Outlook"},
+}
+
+// lintUnsupportedAtRules lists css at-rules and the common clients ignoring them.
+var lintUnsupportedAtRules = map[string][]string{
+	"@import":    {"Gmail", "Outlook"},
+	"@font-face": {"Gmail", "Outlook"},
+	"@supports":  {"Gmail", "Outlook"},
+}
+
+var (
+	cssDeclarationPattern = regexp.MustCompile(`(?i)(?:^|[;{\s])(-?[a-z][a-z-]*)\s*:\s*([^;{}]*)`)
+	cssAtRulePattern      = regexp.MustCompile(`(?i)@[a-z-]+`)
+)
+
+func (l *emailLinter) css(doc *htmlDocument) {
+	doc.root.walk(func(n *htmlNode) {
+		if n.kind != htmlElementNode {
+			return
+		}
+		if style, found := n.attr("style"); found {
+			for _, d := range parseCSSDeclarations(style) {
+				l.cssDeclaration(n.start, d.property, d.value)
+			}
+		}
+		if n.tag != "style" || len(n.children) == 0 {
+			return
+		}
+		text := n.children[0]
+		css := blankCSSComments(l.source[text.start:text.end])
+		for _, m := range cssDeclarationPattern.FindAllStringSubmatchIndex(css, -1) {
+			l.cssDeclaration(text.start+m[2], strings.ToLower(css[m[2]:m[3]]), strings.TrimSpace(css[m[4]:m[5]]))
+		}
+		for _, loc := range cssAtRulePattern.FindAllStringIndex(css, -1) {
+			rule := strings.ToLower(css[loc[0]:loc[1]])
+			if clients, found := lintUnsupportedAtRules[rule]; found {
+				l.report(LintUnsupportedCSS, LintSeverityWarning, text.start+loc[0], "%s is not supported by %s", rule, strings.Join(clients, ", "))
+			}
+		}
+	})
+}
+
+func (l *emailLinter) cssDeclaration(offset int, property string, value string) {
+	if clients, found := lintUnsupportedCSS[property]; found {
+		l.report(LintUnsupportedCSS, LintSeverityWarning, offset, "css %s is not supported by %s", property, strings.Join(clients, ", "))
+	}
+	value = strings.ToLower(value)
+	switch {
+	case property == "display" && (strings.Contains(value, "flex") || strings.Contains(value, "grid")):
+		l.report(LintUnsupportedCSS, LintSeverityWarning, offset, "css display:%s is not supported by Outlook", value)
+	case strings.Contains(value, "var(--"):
+		l.report(LintUnsupportedCSS, LintSeverityWarning, offset, "css variables in %s are not supported by Gmail, Outlook", property)
+	}
+}
+
+// blankCSSComments replaces comments with spaces, keeping offsets.
+func blankCSSComments(css string) string {
+	b := []byte(css)
+	for i := 0; i+1 < len(b); i++ {
+		if b[i] != '/' || b[i+1] != '*' {
+			continue
+		}
+		end := strings.Index(css[i+2:], "*/")
+		stop := len(b)
+		if end >= 0 {
+			stop = i + 2 + end + 2
+		}
+		for k := i; k < stop; k++ {
+			if b[k] != '\n' {
+				b[k] = ' '
+			}
+		}
+		i = stop - 1
+	}
+	return string(b)
+}
+
+func (l *emailLinter) size() {
+	if max := l.maxSize(); len(l.source) > max {
+		l.report(LintGmailClipping, LintSeverityWarning, max, "html is %s, Gmail clips messages over %s from here on", formatSize(len(l.source)), formatSize(max))
+	}
+}
+
+func formatSize(n int) string {
+	return fmt.Sprintf("%.1fKB", float64(n)/1024)
+}
+
+type mergeBlock struct {
+	name   string
+	offset int
+}
+
+// mergeBlocks checks that conditional merge tags are balanced.
+func (l *emailLinter) mergeBlocks() {
+	var open []mergeBlock
+	if strings.EqualFold(l.opts.MergeLanguage, MergeLanguageHandlebars) {
+		for _, loc := range handlebarsMergeTag.FindAllStringSubmatchIndex(l.source, -1) {
+			expr := strings.TrimSpace(l.source[loc[2]:loc[3]])
+			fields := strings.Fields(strings.TrimLeft(expr, "#/"))
+			if len(fields) == 0 {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(expr, "#"):
+				open = append(open, mergeBlock{fields[0], loc[0]})
+			case strings.HasPrefix(expr, "/"):
+				if len(open) == 0 {
+					l.report(LintMergeBlocks, LintSeverityError, loc[0], "{{/%s}} closes no block", fields[0])
+				} else if top := open[len(open)-1]; top.name != fields[0] {
+					l.report(LintMergeBlocks, LintSeverityError, loc[0], "{{/%s}} closes {{#%s}} opened on line %d", fields[0], top.name, lineAt(l.source, top.offset))
+					open = open[:len(open)-1]
+				} else {
+					open = open[:len(open)-1]
+				}
+			case fields[0] == "else" && len(open) == 0:
+				l.report(LintMergeBlocks, LintSeverityError, loc[0], "{{else}} outside of a block")
+			}
+		}
+		for _, b := range open {
+			l.report(LintMergeBlocks, LintSeverityError, b.offset, "{{#%s}} is never closed", b.name)
+		}
+		return
+	}
+	for _, loc := range mailchimpMergeTag.FindAllStringSubmatchIndex(l.source, -1) {
+		tag := strings.ToUpper(strings.TrimSpace(l.source[loc[2]:loc[3]]))
+		switch {
+		case strings.HasPrefix(tag, "IF:"), strings.HasPrefix(tag, "IFNOT:"):
+			open = append(open, mergeBlock{tag, loc[0]})
+		case strings.HasPrefix(tag, "ELSEIF:"), tag == "ELSE:":
+			if len(open) == 0 {
+				l.report(LintMergeBlocks, LintSeverityError, loc[0], "*|%s|* outside of an IF block", tag)
+			}
+		case tag == "END:IF":
+			if len(open) == 0 {
+				l.report(LintMergeBlocks, LintSeverityError, loc[0], "*|END:IF|* closes no IF block")
+			} else {
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	for _, b := range open {
+		l.report(LintMergeBlocks, LintSeverityError, b.offset, "*|%s|* is never closed with *|END:IF|*", b.name)
+	}
+}
+
+// unsubscribe checks that marketing content carries an unsubscribe link.
+func (l *emailLinter) unsubscribe() {
+	if !l.opts.Marketing {
+		return
+	}
+	if strings.EqualFold(l.opts.MergeLanguage, MergeLanguageHandlebars) {
+		for _, m := range handlebarsMergeTag.FindAllStringSubmatch(l.source, -1) {
+			if fields := strings.Fields(m[1]); len(fields) > 0 && strings.EqualFold(fields[0], "unsub") {
+				return
+			}
+		}
+		l.report(LintUnsubscribe, LintSeverityError, 0, "marketing content has no {{unsub}} merge tag")
+		return
+	}
+	for _, m := range mailchimpMergeTag.FindAllStringSubmatch(l.source, -1) {
+		tag := strings.ToUpper(strings.TrimSpace(m[1]))
+		if tag == "UNSUB" || strings.HasPrefix(tag, "UNSUB:") {
+			return
+		}
+	}
+	l.report(LintUnsubscribe, LintSeverityError, 0, "marketing content has no *|UNSUB|* merge tag")
+}
+
+// the mc: attributes of MailChimp templates
+var mcAttributes = map[string]bool{
+	"mc:edit": true, "mc:repeatable": true, "mc:variant": true, "mc:hideable": true,
+	"mc:label": true, "mc:allowdesigner": true, "mc:allowtext": true,
+}
+
+// editRegions checks the mc:edit markup of templates.
+func (l *emailLinter) editRegions(doc *htmlDocument) {
+	regions := make(map[string]int)
+	doc.root.walk(func(n *htmlNode) {
+		if n.kind != htmlElementNode {
+			return
+		}
+		for _, a := range n.attrs {
+			if (strings.HasPrefix(a.name, "mc:") || strings.HasPrefix(a.name, "mc-")) && !mcAttributes[a.name] {
+				l.report(LintEditRegions, LintSeverityWarning, n.start, "unknown attribute %s", a.name)
+			}
+		}
+		if _, found := n.attr("mc:variant"); found && !insideAttr(n.parent, "mc:repeatable") {
+			l.report(LintEditRegions, LintSeverityError, n.start, "mc:variant outside of an mc:repeatable element")
+		}
+		name, found := n.attr("mc:edit")
+		if !found {
+			return
+		}
+		switch {
+		case strings.TrimSpace(name) == "":
+			l.report(LintEditRegions, LintSeverityError, n.start, "mc:edit has no region name")
+			return
+		case insideAttr(n.parent, "mc:edit"):
+			l.report(LintEditRegions, LintSeverityError, n.start, "mc:edit region %q is inside another region", name)
+		case !htmlVoidElements[n.tag] && !n.selfClosing && n.closeStart < 0:
+			l.report(LintEditRegions, LintSeverityError, n.start, "mc:edit region %q is not closed, its content runs to the end of its parent", name)
+		}
+		if first, dup := regions[name]; dup && !insideAttr(n.parent, "mc:repeatable") {
+			l.report(LintEditRegions, LintSeverityError, n.start, "mc:edit region %q is already defined on line %d", name, lineAt(l.source, first))
+		} else if !dup {
+			regions[name] = n.start
+		}
+	})
+}
+
+// insideAttr reports whether n or one of its ancestors has the attribute.
+func insideAttr(n *htmlNode, name string) bool {
+	for ; n != nil; n = n.parent {
+		if _, found := n.attr(name); found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func lintSummary(diagnostics []LintDiagnostic) []string {
+	var out []string
+	for _, d := range diagnostics {
+		out = append(out, d.String())
+	}
+	return out
+}
+
+func TestLintEmailHTML(t *testing.T) {
+	source := `<html><head><style>
+/* position: absolute is fine in a comment */
+.hero { position: relative; color: red }
+@font-face { font-family: Brand; }
+</style></head>
+<body>
+<div mc:edit="main">*|IF:VIP|*Hi <img src="a.png" alt="" width="10" height="10">
+<div mc:edit="main">dup</div>
+</div>
+<img src="b.png" style="display: flex">
+*|END:IF|* *|END:IF|*
+<table mc:variant="x"><tr><td mc:edits="y">z</td></tr></table>
+</body></html>`
+	got := lintSummary(LintEmailHTML(source, LintOptions{Marketing: true}))
+	want := []string{
+		"1:1: error: marketing content has no *|UNSUB|* merge tag (unsubscribe)",
+		"3:9: warning: css position is not supported by Gmail, Outlook (css-support)",
+		"4:1: warning: @font-face is not supported by Gmail, Outlook (css-support)",
+		`8:1: error: mc:edit region "main" is inside another region (mc-edit)`,
+		`8:1: error: mc:edit region "main" is already defined on line 7 (mc-edit)`,
+		`10:1: warning: img has no alt attribute, use alt="" for decorative images (img-alt)`,
+		"10:1: warning: img has no width and height attributes, Outlook shows it at its natural size (img-dimensions)",
+		"10:1: warning: css display:flex is not supported by Outlook (css-support)",
+		"11:12: error: *|END:IF|* closes no IF block (merge-if)",
+		"12:1: error: mc:variant outside of an mc:repeatable element (mc-edit)",
+		"12:27: warning: unknown attribute mc:edits (mc-edit)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLintEmailHTMLHandlebars(t *testing.T) {
+	source := "{{#if vip}}{{#each items}}{{name}}{{/if}}\n{{unsub \"https://example.com\"}}{{#with a}}"
+	got := lintSummary(LintEmailHTML(source, LintOptions{Marketing: true, MergeLanguage: MergeLanguageHandlebars}))
+	want := []string{
+		"1:1: error: {{#if}} is never closed (merge-if)",
+		"1:35: error: {{/if}} closes {{#each}} opened on line 1 (merge-if)",
+		"2:32: error: {{#with}} is never closed (merge-if)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLintEmailHTMLSize(t *testing.T) {
+	source := "<p>" + strings.Repeat("x", 200) + "</p>"
+	got := LintEmailHTML(source, LintOptions{MaxSize: 100})
+	if len(got) != 1 || got[0].Rule != LintGmailClipping || got[0].Offset != 100 {
+		t.Errorf("wrong diagnostics %v", got)
+	}
+	if got := LintEmailHTML(source, LintOptions{MaxSize: 100, Disable: []string{LintGmailClipping}}); len(got) != 0 {
+		t.Errorf("disabled rule reported %v", got)
+	}
+}
+
+func TestLintCampaignContent(t *testing.T) {
+	content := CampaignCreateContent{Sections: map[string]string{
+		"body":   `<img src="a.png" alt="a" width="1" height="1">`,
+		"footer": `<a href="*|UNSUB|*">unsubscribe</a> *|IF:X|*`,
+	}}
+	got := lintSummary(LintCampaignContent(content, LintOptions{}))
+	want := []string{"footer:1:37: error: *|IF:X|* is never closed with *|END:IF|* (merge-if)"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLintTransactionalTemplate(t *testing.T) {
+	code, err := os.ReadFile("templates/transactional_basic.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagnostics := LintEmailHTML(string(code), LintOptions{Marketing: true})
+	if errs := LintErrors(diagnostics); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	found := false
+	for _, d := range diagnostics {
+		if d.Rule == LintImageAlt && d.Line == 322 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("header image without alt not reported: %v", diagnostics)
+	}
+}
@@ -0,0 +1,436 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"html"
+	"strings"
+)
+
+// A small, forgiving html parser for the email helpers. It builds a tree that
+// keeps the source offsets of every tag, so that callers can rewrite a few tags
+// and copy everything else through untouched, merge tags included.
+
+const (
+	htmlDocumentNode = iota
+	htmlElementNode
+	htmlTextNode
+	htmlCommentNode
+	htmlDoctypeNode
+)
+
+type htmlAttr struct {
+	name  string // lower cased
+	value string // unescaped
+	raw   string // as written in the source
+}
+
+type htmlNode struct {
+	kind     int
+	tag      string // lower cased
+	rawTag   string
+	attrs    []htmlAttr
+	text     string // raw text of text, comment and doctype nodes
+	parent   *htmlNode
+	children []*htmlNode
+	// start and end are the offsets of the start tag, or of the whole node
+	// for text and comments
+	start, end int
+	// closeStart and closeEnd are the offsets of the end tag, -1 when the
+	// element was closed implicitly
+	closeStart, closeEnd int
+	selfClosing          bool
+}
+
+// htmlParseIssue is a problem found while parsing, such as a stray end tag.
+type htmlParseIssue struct {
+	offset  int
+	message string
+}
+
+type htmlDocument struct {
+	source string
+	root   *htmlNode
+	issues []htmlParseIssue
+}
+
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+	"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+var htmlRawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// elements whose end tag may be left out
+var htmlOptionalEndElements = map[string]bool{
+	"html": true, "head": true, "body": true, "p": true, "li": true, "dt": true, "dd": true, "tr": true, "td": true,
+	"th": true, "thead": true, "tbody": true, "tfoot": true, "option": true, "colgroup": true,
+}
+
+// elements that close an open <p>
+var htmlClosesParagraph = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "div": true, "dl": true, "fieldset": true,
+	"footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "menu": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "ul": true,
+}
+
+func (n *htmlNode) attr(name string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.name == name {
+			return a.value, true
+		}
+	}
+	return "", false
+}
+
+// elementChildren returns the child elements of n, skipping text and comments.
+func (n *htmlNode) elementChildren() []*htmlNode {
+	var elements []*htmlNode
+	for _, c := range n.children {
+		if c.kind == htmlElementNode {
+			elements = append(elements, c)
+		}
+	}
+	return elements
+}
+
+// walk calls fn on n and every node below it, in document order.
+func (n *htmlNode) walk(fn func(*htmlNode)) {
+	fn(n)
+	for _, c := range n.children {
+		c.walk(fn)
+	}
+}
+
+// textContent returns the unescaped text below n.
+func (n *htmlNode) textContent() string {
+	var b strings.Builder
+	n.walk(func(c *htmlNode) {
+		if c.kind == htmlTextNode {
+			b.WriteString(html.UnescapeString(c.text))
+		}
+	})
+	return b.String()
+}
+
+func isHTMLNameStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// parseHTML parses an html document or fragment. It never fails, markup it
+// cannot make sense of is kept as text.
+func parseHTML(source string) *htmlDocument {
+	doc := &htmlDocument{source: source, root: &htmlNode{kind: htmlDocumentNode, closeStart: -1, closeEnd: -1}}
+	stack := []*htmlNode{doc.root}
+	current := func() *htmlNode { return stack[len(stack)-1] }
+	appendNode := func(n *htmlNode) {
+		n.parent = current()
+		n.parent.children = append(n.parent.children, n)
+	}
+	// closeTo pops the stack up to and including the element at index i
+	closeTo := func(i int) {
+		stack = stack[:i]
+	}
+	lastOpen := func(tag string, stopAt map[string]bool) int {
+		for i := len(stack) - 1; i > 0; i-- {
+			if stack[i].tag == tag {
+				return i
+			}
+			if stopAt[stack[i].tag] {
+				return -1
+			}
+		}
+		return -1
+	}
+	textStart := 0
+	flushText := func(end int) {
+		if end > textStart {
+			appendNode(&htmlNode{kind: htmlTextNode, text: source[textStart:end], start: textStart, end: end, closeStart: -1, closeEnd: -1})
+		}
+	}
+	i := 0
+	for i < len(source) {
+		if source[i] != '<' || i+1 >= len(source) {
+			i++
+			continue
+		}
+		next := source[i+1]
+		switch {
+		case strings.HasPrefix(source[i:], "<!--"):
+			flushText(i)
+			end := strings.Index(source[i+4:], "-->")
+			if end < 0 {
+				end = len(source)
+			} else {
+				end = i + 4 + end + 3
+			}
+			appendNode(&htmlNode{kind: htmlCommentNode, text: source[i:end], start: i, end: end, closeStart: -1, closeEnd: -1})
+			i, textStart = end, end
+		case next == '!' || next == '?':
+			flushText(i)
+			end := strings.IndexByte(source[i:], '>')
+			if end < 0 {
+				end = len(source)
+			} else {
+				end = i + end + 1
+			}
+			appendNode(&htmlNode{kind: htmlDoctypeNode, text: source[i:end], start: i, end: end, closeStart: -1, closeEnd: -1})
+			i, textStart = end, end
+		case next == '/' && i+2 < len(source) && isHTMLNameStart(source[i+2]):
+			flushText(i)
+			j := i + 2
+			for j < len(source) && !isHTMLSpace(source[j]) && source[j] != '>' && source[j] != '/' {
+				j++
+			}
+			tag := strings.ToLower(source[i+2 : j])
+			end := strings.IndexByte(source[j:], '>')
+			if end < 0 {
+				end = len(source)
+			} else {
+				end = j + end + 1
+			}
+			if open := lastOpen(tag, nil); open > 0 {
+				for k := len(stack) - 1; k > open; k-- {
+					if !htmlOptionalEndElements[stack[k].tag] {
+						doc.issues = append(doc.issues, htmlParseIssue{stack[k].start, "<" + stack[k].tag + "> is not closed before </" + tag + ">"})
+					}
+				}
+				stack[open].closeStart, stack[open].closeEnd = i, end
+				closeTo(open)
+			} else if !htmlVoidElements[tag] {
+				doc.issues = append(doc.issues, htmlParseIssue{i, "</" + tag + "> has no matching start tag"})
+			}
+			i, textStart = end, end
+		case isHTMLNameStart(next):
+			flushText(i)
+			n, end := parseHTMLStartTag(source, i)
+			// implied end tags
+			switch {
+			case htmlClosesParagraph[n.tag]:
+				if open := lastOpen("p", map[string]bool{"table": true, "td": true, "th": true, "li": true, "div": true}); open > 0 {
+					closeTo(open)
+				}
+			case n.tag == "li":
+				if open := lastOpen("li", map[string]bool{"ul": true, "ol": true}); open > 0 {
+					closeTo(open)
+				}
+			case n.tag == "dt" || n.tag == "dd":
+				if open := lastOpen("dd", map[string]bool{"dl": true}); open > 0 {
+					closeTo(open)
+				} else if open := lastOpen("dt", map[string]bool{"dl": true}); open > 0 {
+					closeTo(open)
+				}
+			case n.tag == "td" || n.tag == "th":
+				if open := lastOpen("td", map[string]bool{"tr": true, "table": true}); open > 0 {
+					closeTo(open)
+				} else if open := lastOpen("th", map[string]bool{"tr": true, "table": true}); open > 0 {
+					closeTo(open)
+				}
+			case n.tag == "tr":
+				if open := lastOpen("tr", map[string]bool{"table": true}); open > 0 {
+					closeTo(open)
+				}
+			case n.tag == "option":
+				if open := lastOpen("option", map[string]bool{"select": true}); open > 0 {
+					closeTo(open)
+				}
+			}
+			appendNode(n)
+			i, textStart = end, end
+			if htmlVoidElements[n.tag] || n.selfClosing {
+				continue
+			}
+			if htmlRawTextElements[n.tag] {
+				closeAt := indexFold(source[end:], "</"+n.tag)
+				if closeAt < 0 {
+					doc.issues = append(doc.issues, htmlParseIssue{n.start, "<" + n.tag + "> is never closed"})
+					closeAt = len(source) - end
+				}
+				if closeAt > 0 {
+					n.children = append(n.children, &htmlNode{kind: htmlTextNode, text: source[end : end+closeAt], parent: n,
+						start: end, end: end + closeAt, closeStart: -1, closeEnd: -1})
+				}
+				i = end + closeAt
+				if i < len(source) {
+					closeEnd := strings.IndexByte(source[i:], '>')
+					if closeEnd < 0 {
+						closeEnd = len(source) - i - 1
+					}
+					n.closeStart, n.closeEnd = i, i+closeEnd+1
+					i = n.closeEnd
+				}
+				textStart = i
+				continue
+			}
+			stack = append(stack, n)
+		default:
+			i++
+		}
+	}
+	flushText(len(source))
+	for k := len(stack) - 1; k > 0; k-- {
+		if !htmlOptionalEndElements[stack[k].tag] {
+			doc.issues = append(doc.issues, htmlParseIssue{stack[k].start, "<" + stack[k].tag + "> is never closed"})
+		}
+	}
+	return doc
+}
+
+// parseHTMLStartTag parses the start tag at source[i], which must be '<'
+// followed by a letter, and returns the element and the offset after the tag.
+func parseHTMLStartTag(source string, i int) (*htmlNode, int) {
+	n := &htmlNode{kind: htmlElementNode, start: i, closeStart: -1, closeEnd: -1}
+	j := i + 1
+	for j < len(source) && !isHTMLSpace(source[j]) && source[j] != '>' && source[j] != '/' {
+		j++
+	}
+	n.rawTag = source[i+1 : j]
+	n.tag = strings.ToLower(n.rawTag)
+	for j < len(source) {
+		for j < len(source) && isHTMLSpace(source[j]) {
+			j++
+		}
+		if j >= len(source) {
+			break
+		}
+		if source[j] == '>' {
+			j++
+			n.end = j
+			return n, j
+		}
+		if source[j] == '/' {
+			if j+1 < len(source) && source[j+1] == '>' {
+				n.selfClosing = true
+				n.end = j + 2
+				return n, j + 2
+			}
+			j++
+			continue
+		}
+		attrStart := j
+		for j < len(source) && !isHTMLSpace(source[j]) && source[j] != '>' && source[j] != '=' &&
+			!(source[j] == '/' && j+1 < len(source) && source[j+1] == '>') {
+			j++
+		}
+		name := source[attrStart:j]
+		k := j
+		for k < len(source) && isHTMLSpace(source[k]) {
+			k++
+		}
+		value := ""
+		if k < len(source) && source[k] == '=' {
+			k++
+			for k < len(source) && isHTMLSpace(source[k]) {
+				k++
+			}
+			if k < len(source) && (source[k] == '"' || source[k] == '\'') {
+				quote := source[k]
+				closeQuote := strings.IndexByte(source[k+1:], quote)
+				if closeQuote < 0 {
+					closeQuote = len(source) - k - 1
+				}
+				value = source[k+1 : k+1+closeQuote]
+				k = k + 1 + closeQuote + 1
+				if k > len(source) {
+					k = len(source)
+				}
+			} else {
+				valueStart := k
+				for k < len(source) && !isHTMLSpace(source[k]) && source[k] != '>' {
+					k++
+				}
+				value = source[valueStart:k]
+			}
+			j = k
+		}
+		if name == "" {
+			j++
+			continue
+		}
+		n.attrs = append(n.attrs, htmlAttr{name: strings.ToLower(name), value: html.UnescapeString(value), raw: source[attrStart:j]})
+	}
+	n.end = len(source)
+	return n, len(source)
+}
+
+// indexFold is strings.Index ignoring ASCII case.
+func indexFold(s string, substr string) int {
+	n := len(substr)
+	for i := 0; i+n <= len(s); i++ {
+		if strings.EqualFold(s[i:i+n], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// startTag renders the start tag of n with the given attributes, keeping the
+// original spelling of the tag name.
+func (n *htmlNode) startTag(attrs []htmlAttr) string {
+	var b strings.Builder
+	b.WriteString("<")
+	b.WriteString(n.rawTag)
+	for _, a := range attrs {
+		b.WriteString(" ")
+		b.WriteString(a.raw)
+	}
+	if n.selfClosing {
+		b.WriteString(" /")
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// only these need escaping in a double quoted attribute value
+var htmlAttrEscaper = strings.NewReplacer(`&`, "&amp;", `"`, "&quot;")
+
+// newHTMLAttr returns an attribute written with double quotes.
+func newHTMLAttr(name string, value string) htmlAttr {
+	return htmlAttr{name: name, value: value, raw: name + `="` + htmlAttrEscaper.Replace(value) + `"`}
+}
+
+// htmlEdit replaces source[start:end] with text.
+type htmlEdit struct {
+	start, end int
+	text       string
+}
+
+// applyHTMLEdits applies non overlapping edits, in any order, to source.
+func applyHTMLEdits(source string, edits []htmlEdit) string {
+	sortHTMLEdits(edits)
+	var b strings.Builder
+	last := 0
+	for _, e := range edits {
+		if e.start < last {
+			continue
+		}
+		b.WriteString(source[last:e.start])
+		b.WriteString(e.text)
+		last = e.end
+	}
+	b.WriteString(source[last:])
+	return b.String()
+}
+
+func sortHTMLEdits(edits []htmlEdit) {
+	// insertion sort, edits are nearly always already in order
+	for i := 1; i < len(edits); i++ {
+		for j := i; j > 0 && edits[j].start < edits[j-1].start; j-- {
+			edits[j], edits[j-1] = edits[j-1], edits[j]
+		}
+	}
+}
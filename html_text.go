@@ -0,0 +1,516 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultTextWidth is the line width HtmlToText wraps at by default.
+const DefaultTextWidth = 72
+
+// HtmlToTextOptions controls HtmlToText.
+type HtmlToTextOptions struct {
+	// Width is the column lines are wrapped at, 0 means DefaultTextWidth and
+	// a negative width disables wrapping
+	Width int
+	// InlineLinks writes links as "text (url)" instead of numbered footnotes
+	InlineLinks bool
+}
+
+// HtmlToText converts the html part of an email into its plain text part.
+//
+// Paragraphs are separated by blank lines and wrapped, headings are
+// underlined, lists get bullets or numbers, and tables holding data are laid
+// out in columns while layout tables are flattened. Links are numbered and
+// listed at the end of the text. Hidden content such as preheaders, elements
+// styled display:none, is left out. Merge tags are kept as they are.
+func HtmlToText(source string, opts HtmlToTextOptions) (string, error) {
+	return opts.Convert(source)
+}
+
+// Convert is HtmlToText with these options. Its signature fits MessageRenderer.TextConverter.
+func (opts HtmlToTextOptions) Convert(source string) (string, error) {
+	width := opts.Width
+	if width == 0 {
+		width = DefaultTextWidth
+	}
+	c := &htmlTextConverter{opts: opts, linkIndex: make(map[string]int)}
+	lines := c.blocks(parseHTML(source).root.children, width)
+	if len(c.links) > 0 {
+		lines = append(lines, "")
+		for i, link := range c.links {
+			lines = append(lines, fmt.Sprintf("[%d] %s", i+1, link))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// GenerateText fills the Text of the message from its Html with HtmlToText.
+// Unlike AutoText, the text is generated before sending so it can be checked.
+func (m *Message) GenerateText(opts HtmlToTextOptions) error {
+	if m.Html == "" {
+		return errors.New("html cannot be blank")
+	}
+	text, err := HtmlToText(m.Html, opts)
+	if err != nil {
+		return err
+	}
+	m.Text = text
+	return nil
+}
+
+// keeps a link footnote on the line of the link text, replaced by a space once wrapped
+const textNoBreakSpace = '\u00a0'
+
+type htmlTextConverter struct {
+	opts      HtmlToTextOptions
+	links     []string
+	linkIndex map[string]int
+}
+
+// textBlocks collects the lines of a sequence of blocks, with the inline
+// content of the current paragraph pending in inline.
+type textBlocks struct {
+	width     int
+	lines     []string
+	inline    strings.Builder
+	lastSpace bool
+}
+
+func newTextBlocks(width int) *textBlocks {
+	return &textBlocks{width: width, lastSpace: true}
+}
+
+// text appends inline text, collapsing white space.
+func (b *textBlocks) text(s string) {
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			if !b.lastSpace {
+				b.inline.WriteByte(' ')
+				b.lastSpace = true
+			}
+			continue
+		}
+		b.inline.WriteRune(r)
+		b.lastSpace = false
+	}
+}
+
+func (b *textBlocks) lineBreak() {
+	b.inline.WriteByte('\n')
+	b.lastSpace = true
+}
+
+// flush ends the current paragraph.
+func (b *textBlocks) flush() {
+	paragraph := b.inline.String()
+	b.inline.Reset()
+	b.lastSpace = true
+	if strings.TrimSpace(paragraph) == "" {
+		return
+	}
+	var lines []string
+	for _, line := range strings.Split(paragraph, "\n") {
+		lines = append(lines, wrapText(line, b.width)...)
+	}
+	// a trailing <br> does not make a blank line
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	b.add(lines)
+}
+
+// block ends the current paragraph and adds lines as a block of their own.
+func (b *textBlocks) block(lines []string) {
+	b.flush()
+	b.add(lines)
+}
+
+func (b *textBlocks) add(lines []string) {
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return
+	}
+	if len(b.lines) > 0 {
+		b.lines = append(b.lines, "")
+	}
+	b.lines = append(b.lines, lines...)
+}
+
+// wrapText breaks s into lines of at most width runes, words longer than
+// width, such as urls, get a line of their own.
+func wrapText(s string, width int) []string {
+	words := strings.FieldsFunc(s, func(r rune) bool { return r == ' ' })
+	if len(words) == 0 {
+		return []string{""}
+	}
+	var lines []string
+	line := ""
+	for _, word := range words {
+		word = strings.Replace(word, string(textNoBreakSpace), " ", -1)
+		switch {
+		case line == "":
+			line = word
+		case width > 0 && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width:
+			lines = append(lines, line)
+			line = word
+		default:
+			line += " " + word
+		}
+	}
+	return append(lines, line)
+}
+
+// blocks converts a sequence of nodes into lines, blocks separated by a blank line.
+func (c *htmlTextConverter) blocks(nodes []*htmlNode, width int) []string {
+	b := newTextBlocks(width)
+	for _, n := range nodes {
+		c.inline(n, b)
+	}
+	b.flush()
+	return b.lines
+}
+
+var htmlTextBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "center": true, "dd": true, "div": true,
+	"dl": true, "dt": true, "fieldset": true, "figure": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true, "main": true, "nav": true,
+	"ol": true, "p": true, "pre": true, "section": true, "table": true, "ul": true, "body": true, "html": true,
+}
+
+// the elements that are never rendered
+var htmlTextSkipElements = map[string]bool{
+	"head": true, "style": true, "script": true, "title": true, "template": true, "noscript": true,
+}
+
+func (c *htmlTextConverter) inline(n *htmlNode, b *textBlocks) {
+	switch n.kind {
+	case htmlTextNode:
+		b.text(strings.Replace(html.UnescapeString(n.text), "\u00a0", " ", -1))
+		return
+	case htmlElementNode:
+	default:
+		return
+	}
+	if htmlTextSkipElements[n.tag] || hiddenElement(n) {
+		return
+	}
+	switch {
+	case n.tag == "br":
+		b.lineBreak()
+	case n.tag == "img":
+		if alt, _ := n.attr("alt"); strings.TrimSpace(alt) != "" {
+			b.text(alt)
+		}
+	case n.tag == "a":
+		c.link(n, b)
+	case htmlTextBlockElements[n.tag]:
+		b.block(c.block(n, b.width))
+	default:
+		for _, child := range n.children {
+			c.inline(child, b)
+		}
+	}
+}
+
+// block converts a block element into lines.
+func (c *htmlTextConverter) block(n *htmlNode, width int) []string {
+	switch n.tag {
+	case "ul", "ol":
+		return c.list(n, width)
+	case "table":
+		return c.table(n, width)
+	case "blockquote":
+		lines := c.blocks(n.children, narrower(width, 2))
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return lines
+	case "pre":
+		text := strings.TrimPrefix(n.textContent(), "\n")
+		lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " \t\r")
+		}
+		return lines
+	case "hr":
+		if width <= 0 || width > 40 {
+			return []string{strings.Repeat("-", 40)}
+		}
+		return []string{strings.Repeat("-", width)}
+	case "h1", "h2":
+		lines := c.blocks(n.children, width)
+		underline := "="
+		if n.tag == "h2" {
+			underline = "-"
+		}
+		longest := 0
+		for _, line := range lines {
+			if l := utf8.RuneCountInString(line); l > longest {
+				longest = l
+			}
+		}
+		if longest > 0 {
+			lines = append(lines, strings.Repeat(underline, longest))
+		}
+		return lines
+	}
+	return c.blocks(n.children, width)
+}
+
+func narrower(width int, indent int) int {
+	if width <= 0 {
+		return width
+	}
+	if width-indent < 20 {
+		return 20
+	}
+	return width - indent
+}
+
+func (c *htmlTextConverter) list(n *htmlNode, width int) []string {
+	number := 1
+	if start, found := n.attr("start"); found {
+		if i, err := strconv.Atoi(start); err == nil {
+			number = i
+		}
+	}
+	var lines []string
+	for _, item := range n.elementChildren() {
+		if item.tag != "li" || hiddenElement(item) {
+			continue
+		}
+		marker := "* "
+		if n.tag == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		indent := strings.Repeat(" ", len(marker))
+		for i, line := range c.blocks(item.children, narrower(width, len(marker))) {
+			switch {
+			case i == 0:
+				lines = append(lines, marker+line)
+			case line == "":
+				lines = append(lines, "")
+			default:
+				lines = append(lines, indent+line)
+			}
+		}
+	}
+	return lines
+}
+
+// table lays out data tables in columns. Tables used for layout, the ones
+// whose cells hold more than a line or other tables, are flattened into a
+// sequence of blocks.
+func (c *htmlTextConverter) table(n *htmlNode, width int) []string {
+	var rows [][][]string
+	var headerRow bool
+	for _, tr := range tableRows(n) {
+		var cells [][]string
+		header := true
+		for _, cell := range tr.elementChildren() {
+			if cell.tag != "td" && cell.tag != "th" {
+				continue
+			}
+			if cell.tag != "th" {
+				header = false
+			}
+			if hiddenElement(cell) {
+				cells = append(cells, nil)
+				continue
+			}
+			cells = append(cells, c.blocks(cell.children, width))
+		}
+		if len(rows) == 0 {
+			headerRow = header && len(cells) > 0
+		}
+		rows = append(rows, cells)
+	}
+	if columns, ok := tableColumns(n, rows, width); ok {
+		var lines []string
+		for r, cells := range rows {
+			var line strings.Builder
+			for i, cell := range cells {
+				text := ""
+				if len(cell) > 0 {
+					text = cell[0]
+				}
+				line.WriteString(text)
+				if i < len(cells)-1 {
+					line.WriteString(strings.Repeat(" ", columns[i]-utf8.RuneCountInString(text)+2))
+				}
+			}
+			lines = append(lines, strings.TrimRight(line.String(), " "))
+			if r == 0 && headerRow {
+				var rule []string
+				for _, w := range columns {
+					rule = append(rule, strings.Repeat("-", w))
+				}
+				lines = append(lines, strings.Join(rule, "  "))
+			}
+		}
+		return lines
+	}
+	b := newTextBlocks(width)
+	for _, cells := range rows {
+		for _, cell := range cells {
+			b.add(cell)
+		}
+	}
+	return b.lines
+}
+
+// tableColumns returns the column widths when the table is laid out in
+// columns: every cell holds at most one line, a row has at least two cells
+// with text, no cell holds a table and the columns fit the width.
+func tableColumns(n *htmlNode, rows [][][]string, width int) ([]int, bool) {
+	var columns []int
+	multiple := false
+	for _, cells := range rows {
+		filled := 0
+		for i, cell := range cells {
+			if len(cell) > 1 {
+				return nil, false
+			}
+			if i >= len(columns) {
+				columns = append(columns, 0)
+			}
+			if len(cell) == 1 {
+				filled++
+				if l := utf8.RuneCountInString(cell[0]); l > columns[i] {
+					columns[i] = l
+				}
+			}
+		}
+		if filled > 1 {
+			multiple = true
+		}
+	}
+	if !multiple {
+		return nil, false
+	}
+	nested := false
+	for _, child := range n.children {
+		child.walk(func(d *htmlNode) {
+			if d.tag == "table" {
+				nested = true
+			}
+		})
+	}
+	if nested {
+		return nil, false
+	}
+	total := 2 * (len(columns) - 1)
+	for _, w := range columns {
+		total += w
+	}
+	if width > 0 && total > width {
+		return nil, false
+	}
+	return columns, true
+}
+
+// tableRows returns the rows of a table, including the ones of thead, tbody and tfoot.
+func tableRows(table *htmlNode) []*htmlNode {
+	var rows []*htmlNode
+	for _, child := range table.elementChildren() {
+		switch child.tag {
+		case "tr":
+			rows = append(rows, child)
+		case "thead", "tbody", "tfoot":
+			for _, tr := range child.elementChildren() {
+				if tr.tag == "tr" {
+					rows = append(rows, tr)
+				}
+			}
+		}
+	}
+	return rows
+}
+
+func (c *htmlTextConverter) link(n *htmlNode, b *textBlocks) {
+	sub := newTextBlocks(-1)
+	for _, child := range n.children {
+		c.inline(child, sub)
+	}
+	sub.flush()
+	text := strings.Join(strings.Fields(strings.Join(sub.lines, " ")), " ")
+	href, _ := n.attr("href")
+	href = strings.TrimSpace(href)
+	lower := strings.ToLower(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(lower, "javascript:") {
+		b.text(text)
+		return
+	}
+	switch {
+	case text == "":
+		b.text(strings.TrimPrefix(href, "mailto:"))
+	case sameLink(text, href):
+		b.text(text)
+	case c.opts.InlineLinks:
+		b.text(text + " (" + href + ")")
+	default:
+		i, found := c.linkIndex[href]
+		if !found {
+			c.links = append(c.links, href)
+			i = len(c.links)
+			c.linkIndex[href] = i
+		}
+		b.text(text)
+		b.inline.WriteString(fmt.Sprintf("%c[%d]", textNoBreakSpace, i))
+		b.lastSpace = false
+	}
+}
+
+// sameLink reports whether the text of a link is its url, give or take the scheme.
+func sameLink(text string, href string) bool {
+	normalize := func(s string) string {
+		s = strings.ToLower(s)
+		for _, prefix := range []string{"mailto:", "https://", "http://", "www."} {
+			s = strings.TrimPrefix(s, prefix)
+		}
+		return strings.TrimSuffix(s, "/")
+	}
+	return normalize(text) == normalize(href)
+}
+
+// hiddenElement reports whether n is hidden from readers, as email
+// preheaders are.
+func hiddenElement(n *htmlNode) bool {
+	if _, found := n.attr("hidden"); found {
+		return true
+	}
+	if class, _ := n.attr("class"); hasString(strings.Fields(strings.ToLower(class)), "preheader") {
+		return true
+	}
+	style, _ := n.attr("style")
+	style = strings.ToLower(strings.Join(strings.Fields(style), ""))
+	for _, d := range []string{"display:none", "visibility:hidden", "mso-hide:all"} {
+		if strings.Contains(style, d) {
+			return true
+		}
+	}
+	return strings.Contains(style, "max-height:0") && strings.Contains(style, "overflow:hidden")
+}
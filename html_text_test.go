@@ -0,0 +1,92 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"testing"
+)
+
+const htmlTextSource = `<html><head><title>Receipt</title><style>p{color:red}</style></head>
+<body>
+<div class="preheader" style="display:none;max-height:0;overflow:hidden">Your receipt is inside</div>
+<table width="600"><tr><td>
+  <h1>Thanks, *|FNAME|*!</h1>
+  <p>Your order&nbsp;#42 has shipped. Track it on <a href="https://example.com/track/42">our website</a>
+  or read the <a href="https://example.com/faq">FAQ</a>.</p>
+  <table>
+    <tr><th>Item</th><th>Price</th></tr>
+    <tr><td>Widget</td><td>$10.00</td></tr>
+    <tr><td>Shipping</td><td>$2.50</td></tr>
+  </table>
+  <ul><li>Fast</li><li>Cheap<ol start="3"><li>really</li></ol></li></ul>
+  <p>Questions? Write to <a href="mailto:help@example.com">help@example.com</a>
+  or visit <a href="https://example.com/track/42">the tracker</a>.<br>
+  <a href="*|UNSUB|*">Unsubscribe</a></p>
+</td></tr></table>
+</body></html>`
+
+func TestHtmlToText(t *testing.T) {
+	text, err := HtmlToText(htmlTextSource, HtmlToTextOptions{Width: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Thanks, *|FNAME|*!
+==================
+
+Your order #42 has shipped. Track it on
+our website [1] or read the FAQ [2].
+
+Item      Price
+--------  ------
+Widget    $10.00
+Shipping  $2.50
+
+* Fast
+* Cheap
+
+  3. really
+
+Questions? Write to help@example.com or
+visit the tracker [1].
+Unsubscribe [3]
+
+[1] https://example.com/track/42
+[2] https://example.com/faq
+[3] *|UNSUB|*`
+	if text != want {
+		t.Errorf("got\n%s\nwant\n%s", text, want)
+	}
+}
+
+func TestHtmlToTextInlineLinks(t *testing.T) {
+	text, err := HtmlToText(`<p>See <a href="https://example.com">the site</a>, <a href="https://example.com/">example.com</a>.</p><blockquote><p>quoted</p></blockquote>`,
+		HtmlToTextOptions{InlineLinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "See the site (https://example.com), example.com.\n\n> quoted"; text != want {
+		t.Errorf("got %q, want %q", text, want)
+	}
+}
+
+func TestMessageGenerateText(t *testing.T) {
+	message := Message{Html: "<p>Hello <b>there</b></p>"}
+	if err := message.GenerateText(HtmlToTextOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if message.Text != "Hello there" {
+		t.Errorf("wrong text %q", message.Text)
+	}
+	if err := (&Message{}).GenerateText(HtmlToTextOptions{}); err == nil {
+		t.Error("expected an error for a message without html")
+	}
+}
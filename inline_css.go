@@ -0,0 +1,731 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"sort"
+	"strings"
+)
+
+// CSSInlineOptions controls InlineCSS.
+type CSSInlineOptions struct {
+	// StripStyles removes the <style> blocks once their rules are inlined. The
+	// rules that cannot be inlined, such as media queries and :hover, are kept
+	// in a single <style> block at the end of the head.
+	StripStyles bool
+	// Attributes also sets the html attributes older clients such as Outlook
+	// rely on: bgcolor, align and valign on table cells, and width and height
+	// on images and tables.
+	Attributes bool
+}
+
+// InlineCSS moves the rules of the <style> blocks of an html email into the
+// style attributes of the elements they match, the way email clients that
+// ignore <style> need it. It is the local equivalent of ChimpAPI.InlineCSS.
+//
+// Rules are applied in cascade order: !important first, then the element's own
+// style attribute, then selector specificity and source order. Style blocks
+// carrying a data-embed attribute, or a media attribute other than all or
+// screen, are left alone and not inlined.
+func InlineCSS(source string, opts CSSInlineOptions) (string, error) {
+	return opts.Inline(source)
+}
+
+// Inline is InlineCSS with these options. Its signature fits MessageRenderer.CSSInliner.
+func (opts CSSInlineOptions) Inline(source string) (string, error) {
+	doc := parseHTML(source)
+	var styles []*htmlNode
+	var head *htmlNode
+	var rules []cssRule
+	var leftover []string
+	doc.root.walk(func(n *htmlNode) {
+		if n.kind != htmlElementNode {
+			return
+		}
+		if n.tag == "head" && head == nil {
+			head = n
+		}
+		if n.tag != "style" {
+			return
+		}
+		if _, embed := n.attr("data-embed"); embed {
+			return
+		}
+		// blocks for other media, or under a condition, stay where they are
+		if media, found := n.attr("media"); found && !inlinableMedia(media) {
+			return
+		}
+		styles = append(styles, n)
+		sheet := parseStylesheet(n.textContent(), len(rules))
+		rules = append(rules, sheet.rules...)
+		leftover = append(leftover, sheet.leftover...)
+	})
+	if len(styles) == 0 {
+		return source, nil
+	}
+
+	var edits []htmlEdit
+	doc.root.walk(func(n *htmlNode) {
+		if n.kind != htmlElementNode || !inlinableElement(n) {
+			return
+		}
+		var matched []cssDeclarationMatch
+		for _, rule := range rules {
+			if rule.selector.matches(n) {
+				for _, d := range rule.declarations {
+					matched = append(matched, cssDeclarationMatch{cssDeclaration: d, specificity: rule.selector.specificity, order: rule.order})
+				}
+			}
+		}
+		if len(matched) == 0 {
+			return
+		}
+		if style, found := n.attr("style"); found {
+			for _, d := range parseCSSDeclarations(style) {
+				matched = append(matched, cssDeclarationMatch{cssDeclaration: d, inline: true})
+			}
+		}
+		declarations := cascadeDeclarations(matched)
+		attrs := make([]htmlAttr, 0, len(n.attrs)+1)
+		for _, a := range n.attrs {
+			if a.name != "style" {
+				attrs = append(attrs, a)
+			}
+		}
+		attrs = append(attrs, newHTMLAttr("style", formatCSSDeclarations(declarations)))
+		if opts.Attributes {
+			attrs = append(attrs, legacyAttributes(n, declarations)...)
+		}
+		edits = append(edits, htmlEdit{n.start, n.end, n.startTag(attrs)})
+	})
+
+	if opts.StripStyles {
+		var block string
+		if len(leftover) > 0 {
+			block = "<style type=\"text/css\">\n" + strings.Join(leftover, "\n") + "\n</style>"
+			if head != nil && head.closeStart >= 0 {
+				edits = append(edits, htmlEdit{head.closeStart, head.closeStart, block})
+				block = ""
+			}
+		}
+		for _, n := range styles {
+			end := n.closeEnd
+			if end < 0 {
+				end = len(source)
+			}
+			// without a head, the leftover rules replace the first style element
+			edits = append(edits, htmlEdit{n.start, end, block})
+			block = ""
+		}
+	}
+	return applyHTMLEdits(source, edits), nil
+}
+
+// inlinableMedia reports whether the rules of a style block with the media
+// attribute apply to every screen, and so can be inlined.
+func inlinableMedia(media string) bool {
+	switch strings.ToLower(strings.TrimSpace(media)) {
+	case "", "all", "screen":
+		return true
+	}
+	return false
+}
+
+// inlinableElement reports whether styles should be inlined on n, elements in
+// the head are not rendered.
+func inlinableElement(n *htmlNode) bool {
+	switch n.tag {
+	case "head", "style", "script", "title", "meta", "link", "base", "html":
+		return false
+	}
+	for p := n.parent; p != nil; p = p.parent {
+		if p.tag == "head" {
+			return false
+		}
+	}
+	return true
+}
+
+type cssDeclaration struct {
+	property  string
+	value     string
+	important bool
+}
+
+type cssDeclarationMatch struct {
+	cssDeclaration
+	inline      bool
+	specificity [3]int
+	order       int
+}
+
+// cascadeDeclarations returns the winning declaration of each property, in
+// cascade order.
+func cascadeDeclarations(matched []cssDeclarationMatch) []cssDeclaration {
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.important != b.important {
+			return !a.important
+		}
+		if a.inline != b.inline {
+			return !a.inline
+		}
+		if a.specificity != b.specificity {
+			for k := range a.specificity {
+				if a.specificity[k] != b.specificity[k] {
+					return a.specificity[k] < b.specificity[k]
+				}
+			}
+		}
+		return a.order < b.order
+	})
+	winner := make(map[string]int)
+	for i, m := range matched {
+		winner[m.property] = i
+	}
+	var declarations []cssDeclaration
+	for i, m := range matched {
+		if winner[m.property] == i {
+			declarations = append(declarations, m.cssDeclaration)
+		}
+	}
+	return declarations
+}
+
+func formatCSSDeclarations(declarations []cssDeclaration) string {
+	parts := make([]string, len(declarations))
+	for i, d := range declarations {
+		// the style attribute is written with double quotes
+		value := strings.Replace(d.value, `"`, `'`, -1)
+		if d.important {
+			value += " !important"
+		}
+		parts[i] = d.property + ": " + value
+	}
+	return strings.Join(parts, "; ")
+}
+
+// legacyAttributes returns the html attributes matching the inlined styles of
+// n, leaving alone the ones already set.
+func legacyAttributes(n *htmlNode, declarations []cssDeclaration) []htmlAttr {
+	var attrs []htmlAttr
+	set := func(name string, value string) {
+		if _, found := n.attr(name); !found && value != "" {
+			attrs = append(attrs, newHTMLAttr(name, value))
+		}
+	}
+	cell := n.tag == "td" || n.tag == "th"
+	for _, d := range declarations {
+		switch {
+		case d.property == "background-color" && (cell || n.tag == "table"):
+			set("bgcolor", d.value)
+		case d.property == "text-align" && cell:
+			set("align", d.value)
+		case d.property == "vertical-align" && cell:
+			set("valign", d.value)
+		case (d.property == "width" || d.property == "height") && (n.tag == "img" || n.tag == "table" || cell):
+			if strings.HasSuffix(d.value, "px") {
+				set(d.property, strings.TrimSuffix(d.value, "px"))
+			} else if strings.HasSuffix(d.value, "%") && n.tag != "img" {
+				set(d.property, d.value)
+			}
+		}
+	}
+	return attrs
+}
+
+type cssRule struct {
+	selector     cssSelector
+	declarations []cssDeclaration
+	order        int
+}
+
+type cssStylesheet struct {
+	rules []cssRule
+	// leftover holds the css text that cannot be inlined
+	leftover []string
+}
+
+// parseStylesheet splits css into rules that can be inlined, one per
+// selector, and the rest: at-rules and selectors using dynamic pseudo classes.
+func parseStylesheet(css string, order int) cssStylesheet {
+	var sheet cssStylesheet
+	css = stripCSSComments(css)
+	i := 0
+	for i < len(css) {
+		for i < len(css) && (isHTMLSpace(css[i]) || css[i] == ';') {
+			i++
+		}
+		if i >= len(css) {
+			break
+		}
+		if strings.HasPrefix(css[i:], "<!--") || strings.HasPrefix(css[i:], "-->") {
+			i += strings.IndexByte(css[i:], '-') + 3
+			continue
+		}
+		if css[i] == '@' {
+			end := cssAtRuleEnd(css, i)
+			sheet.leftover = append(sheet.leftover, strings.TrimSpace(css[i:end]))
+			i = end
+			continue
+		}
+		open := strings.IndexByte(css[i:], '{')
+		if open < 0 {
+			break
+		}
+		selectors := strings.TrimSpace(css[i : i+open])
+		close, closed := cssBlockEnd(css, i+open)
+		body := css[i+open+1 : close]
+		if closed {
+			body = css[i+open+1 : close-1]
+		}
+		i = close
+		declarations := parseCSSDeclarations(body)
+		var kept []string
+		for _, text := range splitCSSSelectors(selectors) {
+			selector, ok := parseCSSSelector(text)
+			if !ok {
+				kept = append(kept, text)
+				continue
+			}
+			sheet.rules = append(sheet.rules, cssRule{selector: selector, declarations: declarations, order: order})
+			order++
+		}
+		if len(kept) > 0 {
+			sheet.leftover = append(sheet.leftover, strings.Join(kept, ", ")+" {"+strings.TrimSpace(body)+"}")
+		}
+	}
+	return sheet
+}
+
+func stripCSSComments(css string) string {
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			return css
+		}
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return css[:start]
+		}
+		css = css[:start] + css[start+2+end+2:]
+	}
+}
+
+// cssAtRuleEnd returns the offset after the at-rule starting at i, either a
+// statement ending with ';' or a block.
+func cssAtRuleEnd(css string, i int) int {
+	for j := i; j < len(css); j++ {
+		switch css[j] {
+		case ';':
+			return j + 1
+		case '{':
+			end, _ := cssBlockEnd(css, j)
+			return end
+		}
+	}
+	return len(css)
+}
+
+// cssBlockEnd returns the offset after the '}' matching the '{' at open, or
+// len(css) and false when the block is not closed.
+func cssBlockEnd(css string, open int) (int, bool) {
+	depth := 0
+	var quote byte
+	for j := open; j < len(css); j++ {
+		c := css[j]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				j++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return j + 1, true
+			}
+		}
+	}
+	return len(css), false
+}
+
+// splitCSS splits s on sep outside of quotes, parentheses and brackets.
+func splitCSS(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	var quote byte
+	last := 0
+	for j := 0; j < len(s); j++ {
+		c := s[j]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				j++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[last:j])
+			last = j + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+func splitCSSSelectors(selectors string) []string {
+	var out []string
+	for _, s := range splitCSS(selectors, ',') {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// parseCSSDeclarations parses the body of a rule or a style attribute.
+func parseCSSDeclarations(body string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, part := range splitCSS(body, ';') {
+		colon := strings.IndexByte(part, ':')
+		if colon < 0 {
+			continue
+		}
+		d := cssDeclaration{property: strings.ToLower(strings.TrimSpace(part[:colon])), value: strings.TrimSpace(part[colon+1:])}
+		if bang := strings.LastIndexByte(d.value, '!'); bang >= 0 && strings.EqualFold(strings.TrimSpace(d.value[bang+1:]), "important") {
+			d.important = true
+			d.value = strings.TrimSpace(d.value[:bang])
+		}
+		if d.property == "" || d.value == "" {
+			continue
+		}
+		declarations = append(declarations, d)
+	}
+	return declarations
+}
+
+type cssAttrSelector struct {
+	name  string
+	op    string // "", "=", "~=", "|=", "^=", "$=", "*="
+	value string
+}
+
+type cssCompound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []cssAttrSelector
+	pseudos []string
+}
+
+type cssSelector struct {
+	// compounds are in source order, combinators[i] joins compounds[i] and compounds[i+1]
+	compounds   []cssCompound
+	combinators []byte
+	specificity [3]int
+}
+
+// the pseudo classes that depend only on the document, so can be inlined
+var cssStructuralPseudos = map[string]bool{
+	"first-child": true, "last-child": true, "only-child": true,
+	"first-of-type": true, "last-of-type": true, "only-of-type": true, "root": true, "empty": true,
+}
+
+// parseCSSSelector compiles a single selector, ok is false when it uses
+// something that cannot be inlined, such as :hover or ::before.
+func parseCSSSelector(text string) (selector cssSelector, ok bool) {
+	i := 0
+	var compound cssCompound
+	empty := true
+	pendingCombinator := byte(0)
+	flush := func() bool {
+		if empty {
+			return false
+		}
+		if len(selector.compounds) > 0 {
+			if pendingCombinator == 0 {
+				pendingCombinator = ' '
+			}
+			selector.combinators = append(selector.combinators, pendingCombinator)
+		}
+		selector.compounds = append(selector.compounds, compound)
+		compound = cssCompound{}
+		empty = true
+		pendingCombinator = 0
+		return true
+	}
+	ident := func() string {
+		start := i
+		for i < len(text) {
+			c := text[i]
+			if c == '-' || c == '_' || (c >= '0' && c <= '9') || isHTMLNameStart(c) || c >= 0x80 {
+				i++
+				continue
+			}
+			if c == '\\' && i+1 < len(text) {
+				i += 2
+				continue
+			}
+			break
+		}
+		return strings.Replace(text[start:i], `\`, "", -1)
+	}
+	for i < len(text) {
+		c := text[i]
+		switch {
+		case isHTMLSpace(c):
+			i++
+			if !empty {
+				flush()
+			}
+		case c == '>' || c == '+' || c == '~':
+			if !empty {
+				flush()
+			}
+			if len(selector.compounds) == 0 || pendingCombinator != 0 {
+				return selector, false
+			}
+			pendingCombinator = c
+			i++
+		case c == '*':
+			compound.tag = "*"
+			empty = false
+			i++
+		case c == '#':
+			i++
+			compound.id = ident()
+			if compound.id == "" {
+				return selector, false
+			}
+			selector.specificity[0]++
+			empty = false
+		case c == '.':
+			i++
+			class := ident()
+			if class == "" {
+				return selector, false
+			}
+			compound.classes = append(compound.classes, class)
+			selector.specificity[1]++
+			empty = false
+		case c == '[':
+			end := strings.IndexByte(text[i:], ']')
+			if end < 0 {
+				return selector, false
+			}
+			attr, valid := parseCSSAttrSelector(text[i+1 : i+end])
+			if !valid {
+				return selector, false
+			}
+			compound.attrs = append(compound.attrs, attr)
+			selector.specificity[1]++
+			empty = false
+			i += end + 1
+		case c == ':':
+			i++
+			if i < len(text) && text[i] == ':' {
+				// pseudo elements cannot be inlined
+				return selector, false
+			}
+			pseudo := strings.ToLower(ident())
+			if !cssStructuralPseudos[pseudo] {
+				return selector, false
+			}
+			compound.pseudos = append(compound.pseudos, pseudo)
+			selector.specificity[1]++
+			empty = false
+		case isHTMLNameStart(c):
+			compound.tag = strings.ToLower(ident())
+			selector.specificity[2]++
+			empty = false
+		default:
+			return selector, false
+		}
+	}
+	if !flush() || pendingCombinator != 0 {
+		return selector, false
+	}
+	return selector, true
+}
+
+func parseCSSAttrSelector(s string) (cssAttrSelector, bool) {
+	s = strings.TrimSpace(s)
+	for _, op := range []string{"~=", "|=", "^=", "$=", "*=", "="} {
+		if i := strings.Index(s, op); i > 0 {
+			value := strings.TrimSpace(s[i+len(op):])
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			return cssAttrSelector{name: strings.ToLower(strings.TrimSpace(s[:i])), op: op, value: value}, true
+		}
+	}
+	if s == "" || strings.ContainsAny(s, " \"'") {
+		return cssAttrSelector{}, false
+	}
+	return cssAttrSelector{name: strings.ToLower(s)}, true
+}
+
+func (s cssSelector) matches(n *htmlNode) bool {
+	return s.matchesAt(n, len(s.compounds)-1)
+}
+
+func (s cssSelector) matchesAt(n *htmlNode, i int) bool {
+	if !s.compounds[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	switch s.combinators[i-1] {
+	case '>':
+		p := n.parent
+		return p != nil && p.kind == htmlElementNode && s.matchesAt(p, i-1)
+	case '+':
+		prev := previousElementSibling(n)
+		return prev != nil && s.matchesAt(prev, i-1)
+	case '~':
+		for prev := previousElementSibling(n); prev != nil; prev = previousElementSibling(prev) {
+			if s.matchesAt(prev, i-1) {
+				return true
+			}
+		}
+		return false
+	default:
+		for p := n.parent; p != nil && p.kind == htmlElementNode; p = p.parent {
+			if s.matchesAt(p, i-1) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func previousElementSibling(n *htmlNode) *htmlNode {
+	if n.parent == nil {
+		return nil
+	}
+	var prev *htmlNode
+	for _, c := range n.parent.children {
+		if c == n {
+			return prev
+		}
+		if c.kind == htmlElementNode {
+			prev = c
+		}
+	}
+	return nil
+}
+
+func (c cssCompound) matches(n *htmlNode) bool {
+	if c.tag != "" && c.tag != "*" && c.tag != n.tag {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := n.attr("id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		classes := strings.Fields(attrOrEmpty(n, "class"))
+		for _, class := range c.classes {
+			if !hasString(classes, class) {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		value, found := n.attr(a.name)
+		if !found || !a.matches(value) {
+			return false
+		}
+	}
+	for _, pseudo := range c.pseudos {
+		if !matchesStructuralPseudo(n, pseudo) {
+			return false
+		}
+	}
+	return true
+}
+
+func attrOrEmpty(n *htmlNode, name string) string {
+	value, _ := n.attr(name)
+	return value
+}
+
+func (a cssAttrSelector) matches(value string) bool {
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return value == a.value
+	case "~=":
+		return hasString(strings.Fields(value), a.value)
+	case "|=":
+		return value == a.value || strings.HasPrefix(value, a.value+"-")
+	case "^=":
+		return a.value != "" && strings.HasPrefix(value, a.value)
+	case "$=":
+		return a.value != "" && strings.HasSuffix(value, a.value)
+	case "*=":
+		return a.value != "" && strings.Contains(value, a.value)
+	}
+	return false
+}
+
+func matchesStructuralPseudo(n *htmlNode, pseudo string) bool {
+	if pseudo == "root" {
+		return n.parent != nil && n.parent.kind == htmlDocumentNode
+	}
+	if pseudo == "empty" {
+		for _, c := range n.children {
+			if c.kind == htmlElementNode || (c.kind == htmlTextNode && c.text != "") {
+				return false
+			}
+		}
+		return true
+	}
+	if n.parent == nil {
+		return false
+	}
+	siblings := n.parent.elementChildren()
+	if strings.HasSuffix(pseudo, "-of-type") {
+		var sameType []*htmlNode
+		for _, s := range siblings {
+			if s.tag == n.tag {
+				sameType = append(sameType, s)
+			}
+		}
+		siblings = sameType
+	}
+	first := len(siblings) > 0 && siblings[0] == n
+	last := len(siblings) > 0 && siblings[len(siblings)-1] == n
+	switch strings.TrimSuffix(strings.TrimSuffix(pseudo, "-child"), "-of-type") {
+	case "first":
+		return first
+	case "last":
+		return last
+	case "only":
+		return first && last
+	}
+	return false
+}
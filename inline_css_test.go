@@ -0,0 +1,139 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"strings"
+	"testing"
+)
+
+const inlineCSSSource = `<html><head><title>t</title>
+<style>
+/* base */
+p { color: black; margin: 0 }
+.lead { color: red }
+#intro.lead { color: green }
+td > a, a:hover { color: blue; font-family: "Helvetica Neue" }
+.keep { color: gray !important }
+@media only screen and (max-width: 600px) { p { font-size: 18px } }
+</style>
+</head><body>
+<p id="intro" class="lead">Hello *|FNAME|*</p>
+<p class="lead keep" style="color: purple; padding: 1px">Fine print</p>
+<table><tr><td style="text-align:center"><a href="*|UNSUB|*">unsubscribe</a></td></tr></table>
+</body></html>`
+
+func TestInlineCSS(t *testing.T) {
+	out, err := InlineCSS(inlineCSSSource, CSSInlineOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<p id="intro" class="lead" style="margin: 0; color: green">Hello *|FNAME|*</p>`,
+		`<p class="lead keep" style="margin: 0; padding: 1px; color: gray !important">Fine print</p>`,
+		`<td style="text-align:center"><a href="*|UNSUB|*" style="color: blue; font-family: 'Helvetica Neue'">`,
+		`@media only screen`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in\n%s", want, out)
+		}
+	}
+}
+
+func TestInlineCSSStripStyles(t *testing.T) {
+	out, err := InlineCSS(inlineCSSSource, CSSInlineOptions{StripStyles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "/* base */") || strings.Contains(out, "#intro") {
+		t.Errorf("source styles not stripped:\n%s", out)
+	}
+	want := "<style type=\"text/css\">\na:hover {color: blue; font-family: \"Helvetica Neue\"}\n@media only screen and (max-width: 600px) { p { font-size: 18px } }\n</style></head>"
+	if !strings.Contains(out, want) {
+		t.Errorf("leftover rules not kept in the head:\n%s", out)
+	}
+}
+
+func TestInlineCSSAttributes(t *testing.T) {
+	source := `<style>td.cell { background-color: #ffffff; vertical-align: top } img { width: 120px }</style>
+<table><tr><td class="cell" valign="middle"><img src="logo.png"></td></tr></table>`
+	out, err := InlineCSS(source, CSSInlineOptions{StripStyles: true, Attributes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<table><tr><td class="cell" valign="middle" style="background-color: #ffffff; vertical-align: top" bgcolor="#ffffff"><img src="logo.png" style="width: 120px" width="120"></td></tr></table>`
+	if strings.TrimSpace(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestInlineCSSSelectors(t *testing.T) {
+	source := `<style>li:first-child { a: 1 } li + li { b: 2 } h1 ~ ul li { c: 3 } [data-x^="fo"] { d: 4 } ul li:last-child { e: 5 }</style>
+<h1>x</h1><ul><li>one<li data-x="foo">two<li>three</ul>`
+	out, err := InlineCSS(source, CSSInlineOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<li style="c: 3; a: 1">one`,
+		`<li data-x="foo" style="b: 2; c: 3; d: 4">two`,
+		`<li style="b: 2; c: 3; e: 5">three`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in\n%s", want, out)
+		}
+	}
+}
+
+func TestInlineCSSUnterminated(t *testing.T) {
+	for source, want := range map[string]string{
+		"<style>p {</style><p>x</p>":               `<p>x</p>`,
+		"<style>p { color: re</style><p>x</p>":     `<p style="color: re">x</p>`,
+		"<style>p { color: red; } a {</style><p>x": `<p style="color: red">x`,
+		"<style>@media screen { p {</style><p>x":   `<p>x`,
+	} {
+		out, err := InlineCSS(source, CSSInlineOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", source, err)
+			continue
+		}
+		if !strings.Contains(out, want) {
+			t.Errorf("%s: missing %s in %s", source, want, out)
+		}
+	}
+}
+
+func TestInlineCSSStripStylesWithoutHead(t *testing.T) {
+	source := "<style>p { color: red } a:hover { color: blue } @media screen and (max-width: 600px) { p { font-size: 18px } }</style>\n<p>Hi <a href=\"#\">there</a></p>"
+	out, err := InlineCSS(source, CSSInlineOptions{StripStyles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<style type=\"text/css\">\na:hover {color: blue}\n@media screen and (max-width: 600px) { p { font-size: 18px } }\n</style>\n<p style=\"color: red\">"
+	if !strings.HasPrefix(out, want) {
+		t.Errorf("leftover rules lost:\n%s", out)
+	}
+}
+
+func TestInlineCSSMediaAttribute(t *testing.T) {
+	source := `<html><head><style media="screen and (max-width:600px)">p{color:red}</style><style media=" Screen ">p{margin:0}</style>` +
+		`<style media="print">p{color:black}</style></head><body><p>x</p></body></html>`
+	out, err := InlineCSS(source, CSSInlineOptions{StripStyles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<html><head><style media="screen and (max-width:600px)">p{color:red}</style>` +
+		`<style media="print">p{color:black}</style></head><body><p style="margin: 0">x</p></body></html>`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// the states of a Delivery, those of MessageInfo and of SendResponse.Status
+const (
+	DeliveryQueued      = "queued"
+	DeliveryScheduled   = "scheduled"
+	DeliveryDeferred    = "deferred"
+	DeliverySent        = "sent"
+	DeliveryBounced     = "bounced"
+	DeliverySoftBounced = "soft-bounced"
+	DeliveryRejected    = "rejected"
+	DeliveryInvalid     = "invalid"
+)
+
+// the default polling backoff of a DeliveryTracker
+const (
+	DefaultDeliveryMinBackoff = 30 * time.Second
+	DefaultDeliveryMaxBackoff = 30 * time.Minute
+)
+
+// Delivery is the state of a message sent to one recipient.
+type Delivery struct {
+	Id    string `json:"_id"`
+	Email string `json:"email"`
+	State string `json:"state"`
+	// Final reports whether State will not change anymore
+	Final bool `json:"final"`
+	// Deferred reports whether delivery was deferred before State
+	Deferred bool `json:"deferred"`
+	// Diag is the SMTP diagnostic or the reject reason, when there is one
+	Diag         string    `json:"diag"`
+	RegisteredAt time.Time `json:"registered_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// Polls is the number of MessageInfo calls made so far, NextPoll when the
+	// next one is due
+	Polls    int       `json:"polls"`
+	NextPoll time.Time `json:"next_poll"`
+}
+
+// isFinalDelivery reports whether a message in state will not change anymore.
+func isFinalDelivery(state string) bool {
+	switch state {
+	case DeliverySent, DeliveryBounced, DeliverySoftBounced, DeliveryRejected, DeliveryInvalid:
+		return true
+	}
+	return false
+}
+
+// overridesSent reports whether state replaces a final state, a message
+// accepted as sent can still bounce or be rejected by the receiving server.
+func overridesSent(final string, state string) bool {
+	if final != DeliverySent {
+		return false
+	}
+	return state == DeliveryBounced || state == DeliverySoftBounced || state == DeliveryRejected
+}
+
+// DeliveryStore keeps the state of tracked deliveries.
+type DeliveryStore interface {
+	Save(delivery Delivery) error
+	// Get returns a delivery, found is false when the id is not tracked
+	Get(id string) (delivery Delivery, found bool, err error)
+	// Pending returns the deliveries that are not final
+	Pending() ([]Delivery, error)
+}
+
+// MemoryDeliveryStore is a DeliveryStore keeping deliveries in memory.
+type MemoryDeliveryStore struct {
+	mu         sync.Mutex
+	deliveries map[string]Delivery
+}
+
+func NewMemoryDeliveryStore() *MemoryDeliveryStore {
+	return &MemoryDeliveryStore{deliveries: make(map[string]Delivery)}
+}
+
+func (s *MemoryDeliveryStore) Save(delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[delivery.Id] = delivery
+	return nil
+}
+
+func (s *MemoryDeliveryStore) Get(id string) (Delivery, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery, found := s.deliveries[id]
+	return delivery, found, nil
+}
+
+func (s *MemoryDeliveryStore) Pending() ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending []Delivery
+	for _, delivery := range s.deliveries {
+		if !delivery.Final {
+			pending = append(pending, delivery)
+		}
+	}
+	return pending, nil
+}
+
+// DeliveryFunc is notified of a delivery that reached its final state.
+type DeliveryFunc func(delivery Delivery)
+
+// DeliveryTracker follows the messages returned by MessageSend until they
+// reach a final state. Register HandleEvent on a WebhookHandler to learn states
+// from webhook events, and call Poll, or Run, to ask MessageInfo about the
+// deliveries no event resolved, less and less often.
+//
+// A message reported sent is not polled further, but a bounce or reject event
+// that comes later overrides sent and resolves the delivery again. Other final
+// states do not change.
+type DeliveryTracker struct {
+	API   *MandrillAPI
+	Store DeliveryStore
+	// MinBackoff is the wait before the first poll, it doubles after each
+	// poll up to MaxBackoff. 0 means the defaults.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	mu       sync.Mutex
+	handlers []DeliveryFunc
+	channels []chan<- Delivery
+	now      func() time.Time
+}
+
+// NewDeliveryTracker returns a tracker polling api and keeping its state in memory.
+func NewDeliveryTracker(api *MandrillAPI) *DeliveryTracker {
+	return &DeliveryTracker{API: api, Store: NewMemoryDeliveryStore()}
+}
+
+// OnResolved registers fn for the deliveries that reach their final state,
+// again when a bounce overrides sent. Callbacks must be registered before
+// messages are tracked.
+func (t *DeliveryTracker) OnResolved(fn DeliveryFunc) {
+	t.handlers = append(t.handlers, fn)
+}
+
+// Notify sends the deliveries that reach their final state on ch. The tracker
+// does not block sending: when ch is not ready the delivery is dropped, so ch
+// should be buffered.
+func (t *DeliveryTracker) Notify(ch chan<- Delivery) {
+	t.channels = append(t.channels, ch)
+}
+
+func (t *DeliveryTracker) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func (t *DeliveryTracker) backoff(polls int) time.Duration {
+	min, max := t.MinBackoff, t.MaxBackoff
+	if min <= 0 {
+		min = DefaultDeliveryMinBackoff
+	}
+	if max <= 0 {
+		max = DefaultDeliveryMaxBackoff
+	}
+	wait := min
+	for i := 0; i < polls && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// Track registers the responses of MessageSend. Responses already final, sent,
+// rejected or invalid, are resolved at once.
+func (t *DeliveryTracker) Track(responses ...SendResponse) error {
+	now := t.clock()
+	for _, response := range responses {
+		if response.Id == "" {
+			return errors.New("id cannot be blank")
+		}
+		delivery := Delivery{Id: response.Id, Email: response.Email, RegisteredAt: now, NextPoll: now.Add(t.backoff(0))}
+		if err := t.update(delivery, response.Status, response.RejectedReason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleEvent updates the delivery of a message event, it is a
+// WebhookEventFunc. Events of untracked messages are ignored.
+func (t *DeliveryTracker) HandleEvent(event WebhookEvent) error {
+	if event.Msg == nil {
+		return nil
+	}
+	id := event.Id
+	if id == "" {
+		id = event.Msg.Id
+	}
+	t.mu.Lock()
+	delivery, found, err := t.Store.Get(id)
+	t.mu.Unlock()
+	if err != nil || !found {
+		return err
+	}
+	switch event.Event {
+	case WebhookEventSend:
+		return t.update(delivery, DeliverySent, "")
+	case WebhookEventDeferral:
+		return t.update(delivery, DeliveryDeferred, smtpDiag(event.Msg.SMTPEvents))
+	case WebhookEventHardBounce:
+		return t.update(delivery, DeliveryBounced, event.Msg.Diag)
+	case WebhookEventSoftBounce:
+		return t.update(delivery, DeliverySoftBounced, event.Msg.Diag)
+	case WebhookEventReject:
+		return t.update(delivery, DeliveryRejected, "")
+	case WebhookEventOpen, WebhookEventClick, WebhookEventSpam, WebhookEventUnsub:
+		// the message could not be opened or reported without being sent
+		return t.update(delivery, DeliverySent, "")
+	}
+	return nil
+}
+
+func smtpDiag(events []SMTPEvent) string {
+	if len(events) == 0 {
+		return ""
+	}
+	return events[len(events)-1].Diagnostics
+}
+
+// Poll asks MessageInfo about every pending delivery whose next poll is due.
+// It returns the first error, after polling the others.
+func (t *DeliveryTracker) Poll() error {
+	if t.API == nil {
+		return errors.New("DeliveryTracker has no API to poll")
+	}
+	t.mu.Lock()
+	pending, err := t.Store.Pending()
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, delivery := range pending {
+		if t.clock().Before(delivery.NextPoll) {
+			continue
+		}
+		if err := t.poll(delivery); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t *DeliveryTracker) poll(delivery Delivery) error {
+	info, err := t.API.MessageInfo(delivery.Id)
+	delivery.Polls++
+	delivery.NextPoll = t.clock().Add(t.backoff(delivery.Polls))
+	if err != nil {
+		if apiErr, ok := err.(MandrillError); ok && apiErr.Name == "Unknown_Message" {
+			// the message is not searchable yet
+			err = nil
+		}
+		if saveErr := t.update(delivery, delivery.State, delivery.Diag); saveErr != nil {
+			return saveErr
+		}
+		if err != nil {
+			return fmt.Errorf("message %s: %v", delivery.Id, err)
+		}
+		return nil
+	}
+	state, _ := info["state"].(string)
+	diag, _ := info["diag"].(string)
+	if events, ok := info["smtp_events"].([]interface{}); ok {
+		for _, e := range events {
+			if e, ok := e.(map[string]interface{}); ok && e["type"] == DeliveryDeferred {
+				delivery.Deferred = true
+				if diag == "" {
+					diag, _ = e["diag"].(string)
+				}
+			}
+		}
+	}
+	switch state {
+	case "spam", "unsub":
+		state = DeliverySent
+	case "":
+		state = delivery.State
+	}
+	return t.update(delivery, state, diag)
+}
+
+// update moves a delivery to a state, saving it and notifying its resolution.
+// A delivery already final does not change, unless sent is overridden.
+func (t *DeliveryTracker) update(delivery Delivery, state string, diag string) error {
+	t.mu.Lock()
+	stored, found, err := t.Store.Get(delivery.Id)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	if found {
+		if stored.Final && !overridesSent(stored.State, state) {
+			t.mu.Unlock()
+			return nil
+		}
+		delivery.Deferred = delivery.Deferred || stored.Deferred
+		if delivery.Polls < stored.Polls {
+			delivery.Polls, delivery.NextPoll = stored.Polls, stored.NextPoll
+		}
+	}
+	if state == DeliveryDeferred {
+		delivery.Deferred = true
+	}
+	if state != delivery.State {
+		delivery.UpdatedAt = t.clock()
+	}
+	delivery.State = state
+	if diag != "" {
+		delivery.Diag = diag
+	}
+	delivery.Final = isFinalDelivery(state)
+	err = t.Store.Save(delivery)
+	t.mu.Unlock()
+	if err != nil || !delivery.Final {
+		return err
+	}
+	for _, fn := range t.handlers {
+		fn(delivery)
+	}
+	for _, ch := range t.channels {
+		select {
+		case ch <- delivery:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run polls every interval until stop is closed.
+func (t *DeliveryTracker) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			t.Poll()
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeliveryTracker(t *testing.T) {
+	// info holds the MessageInfo answers by id, missing ids are unknown
+	info := map[string]string{}
+	polled := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&params)
+		id := params["id"].(string)
+		polled[id]++
+		answer, found := info[id]
+		if !found {
+			w.WriteHeader(http.StatusInternalServerError)
+			answer = `{"status":"error","code":11,"name":"Unknown_Message","message":"No message exists with the id"}`
+		}
+		w.Write([]byte(answer))
+	}))
+	defer srv.Close()
+
+	now := time.Date(2013, 4, 4, 12, 0, 0, 0, time.UTC)
+	tracker := NewDeliveryTracker(&MandrillAPI{endpoint: srv.URL})
+	tracker.now = func() time.Time { return now }
+	resolved := make(map[string]Delivery)
+	tracker.OnResolved(func(delivery Delivery) {
+		resolved[delivery.Id] = delivery
+	})
+	ch := make(chan Delivery, 10)
+	tracker.Notify(ch)
+
+	err := tracker.Track(
+		SendResponse{Id: "a", Email: "a@example.com", Status: "queued"},
+		SendResponse{Id: "b", Email: "b@example.com", Status: "queued"},
+		SendResponse{Id: "c", Email: "c@example.com", Status: "rejected", RejectedReason: "hard-bounce"},
+		SendResponse{Id: "d", Email: "d@example.com", Status: "queued"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved["c"].State != DeliveryRejected || resolved["c"].Diag != "hard-bounce" || len(ch) != 1 {
+		t.Errorf("rejected not resolved %+v", resolved)
+	}
+
+	// webhook events resolve a and defer b
+	h := &WebhookHandler{}
+	h.OnAny(tracker.HandleEvent)
+	events, err := ParseWebhookEvents(`[
+		{"event":"hard_bounce","ts":1365076800,"_id":"a","msg":{"_id":"a","state":"bounced","diag":"smtp;550 5.1.1 user unknown"}},
+		{"event":"deferral","ts":1365076800,"_id":"b","msg":{"_id":"b","state":"deferred","smtp_events":[{"type":"deferred","diag":"451 busy"}]}},
+		{"event":"send","ts":1365076800,"_id":"untracked","msg":{"_id":"untracked"}}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Dispatch(events); err != nil {
+		t.Fatal(err)
+	}
+	if resolved["a"].State != DeliveryBounced || resolved["a"].Diag != "smtp;550 5.1.1 user unknown" {
+		t.Errorf("bounce not resolved %+v", resolved["a"])
+	}
+
+	// nothing is due before the first backoff
+	if err := tracker.Poll(); err != nil || len(polled) != 0 {
+		t.Fatalf("polled early %v %v", polled, err)
+	}
+	now = now.Add(DefaultDeliveryMinBackoff)
+	info["b"] = `{"_id":"b","state":"sent","smtp_events":[{"type":"deferred","diag":"451 busy"},{"type":"sent","diag":"250 OK"}]}`
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if b := resolved["b"]; b.State != DeliverySent || !b.Deferred || b.Diag != "451 busy" {
+		t.Errorf("deferred then sent not resolved %+v", b)
+	}
+	if polled["a"] != 0 || polled["d"] != 1 {
+		t.Errorf("wrong polls %v", polled)
+	}
+
+	// d is unknown at first, its next poll waits twice as long
+	d, _, _ := tracker.Store.Get("d")
+	if d.Final || d.Polls != 1 || !d.NextPoll.Equal(now.Add(2*DefaultDeliveryMinBackoff)) {
+		t.Errorf("wrong backoff %+v", d)
+	}
+	now = now.Add(time.Minute)
+	info["d"] = `{"_id":"d","state":"soft-bounced","diag":"smtp;552 mailbox full"}`
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if resolved["d"].State != DeliverySoftBounced || polled["d"] != 2 || len(ch) != 4 {
+		t.Errorf("soft bounce not resolved %+v %v", resolved["d"], polled)
+	}
+	if pending, _ := tracker.Store.Pending(); len(pending) != 0 {
+		t.Errorf("still pending %+v", pending)
+	}
+}
+
+func TestDeliveryTrackerBounceAfterSent(t *testing.T) {
+	tracker := NewDeliveryTracker(&MandrillAPI{})
+	var resolved []Delivery
+	tracker.OnResolved(func(delivery Delivery) {
+		resolved = append(resolved, delivery)
+	})
+	if err := tracker.Track(SendResponse{Id: "a", Email: "a@example.com", Status: "sent"}); err != nil {
+		t.Fatal(err)
+	}
+	h := &WebhookHandler{}
+	h.OnAny(tracker.HandleEvent)
+	events, err := ParseWebhookEvents(`[
+		{"event":"hard_bounce","ts":1365076800,"_id":"a","msg":{"_id":"a","state":"bounced","diag":"smtp;550 5.1.1 user unknown"}},
+		{"event":"open","ts":1365076900,"_id":"a","msg":{"_id":"a","state":"sent"}},
+		{"event":"send","ts":1365077000,"_id":"a","msg":{"_id":"a","state":"sent"}}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Dispatch(events); err != nil {
+		t.Fatal(err)
+	}
+	if len(resolved) != 2 || resolved[0].State != DeliverySent || resolved[1].State != DeliveryBounced {
+		t.Fatalf("bounce did not override sent %+v", resolved)
+	}
+	if a, _, _ := tracker.Store.Get("a"); a.State != DeliveryBounced || !a.Final || a.Diag != "smtp;550 5.1.1 user unknown" {
+		t.Errorf("wrong delivery %+v", a)
+	}
+}
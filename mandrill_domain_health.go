@@ -0,0 +1,282 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Mandrill's published DNS requirements for sending domains,
+// see https://mandrill.zendesk.com/hc/en-us/articles/205582277
+const (
+	MandrillSpfInclude   = "spf.mandrillapp.com"
+	MandrillDkimSelector = "mandrill"
+)
+
+// the maximum number of DNS querying mechanisms allowed in an SPF record (RFC 7208 4.6.4)
+const spfLookupLimit = 10
+
+// DnsResolver looks up TXT records. NetResolver uses the system resolver, tests
+// can provide a fake one so that no network is needed.
+type DnsResolver interface {
+	LookupTXT(name string) ([]string, error)
+}
+
+// NetResolver is a DnsResolver backed by the system resolver.
+type NetResolver struct{}
+
+func (NetResolver) LookupTXT(name string) ([]string, error) {
+	return net.LookupTXT(name)
+}
+
+// DomainHealthChecker diagnoses the SPF, DKIM and DMARC records of a sending
+// domain locally, before asking Mandrill to check it with SenderDomainCheck.
+type DomainHealthChecker struct {
+	Resolver     DnsResolver
+	SpfInclude   string
+	DkimSelector string
+}
+
+// NewDomainHealthChecker returns a checker for Mandrill's SPF include and DKIM
+// selector. A nil resolver uses the system resolver.
+func NewDomainHealthChecker(resolver DnsResolver) *DomainHealthChecker {
+	if resolver == nil {
+		resolver = NetResolver{}
+	}
+	return &DomainHealthChecker{Resolver: resolver, SpfInclude: MandrillSpfInclude, DkimSelector: MandrillDkimSelector}
+}
+
+// the severity of a DomainProblem
+const (
+	DomainProblemError   = "error"
+	DomainProblemWarning = "warning"
+)
+
+// DomainProblem is a single actionable issue found with a domain's DNS.
+type DomainProblem struct {
+	Record   string // spf, dkim or dmarc
+	Severity string
+	Message  string
+}
+
+func (p DomainProblem) String() string {
+	return fmt.Sprintf("%s %s: %s", p.Record, p.Severity, p.Message)
+}
+
+// DomainHealth is the result of a local DNS check. The raw records found are
+// kept so they can be shown next to the problems.
+type DomainHealth struct {
+	Domain      string
+	Spf         string
+	Dkim        string
+	Dmarc       string
+	DmarcPolicy string
+	Problems    []DomainProblem
+}
+
+// Healthy reports whether no errors were found. Warnings do not stop Mandrill
+// from signing or sending, so they are not considered.
+func (h DomainHealth) Healthy() bool {
+	for _, p := range h.Problems {
+		if p.Severity == DomainProblemError {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *DomainHealth) problem(record string, severity string, format string, args ...interface{}) {
+	h.Problems = append(h.Problems, DomainProblem{Record: record, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check resolves the SPF, DKIM and DMARC records of a domain returned by
+// SenderDomains and reports what needs fixing.
+func (c *DomainHealthChecker) Check(domain Domain) DomainHealth {
+	name := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain.Domain)), ".")
+	health := DomainHealth{Domain: name}
+	if name == "" {
+		health.problem("spf", DomainProblemError, "domain cannot be blank")
+		return health
+	}
+	c.checkSpf(name, &health)
+	c.checkDkim(name, &health)
+	c.checkDmarc(name, &health)
+	return health
+}
+
+func (c *DomainHealthChecker) checkSpf(domain string, h *DomainHealth) {
+	records, ok := c.lookup("spf", domain, "v=spf1", h)
+	if !ok {
+		return
+	}
+	switch len(records) {
+	case 0:
+		h.problem("spf", DomainProblemError, "no SPF record found, add a TXT record on %s: v=spf1 include:%s ?all", domain, c.SpfInclude)
+		return
+	case 1:
+	default:
+		h.problem("spf", DomainProblemError, "%d SPF records found on %s, merge them into a single record", len(records), domain)
+	}
+	h.Spf = records[0]
+	for _, term := range strings.Fields(records[0])[1:] {
+		if term == "+all" || term == "all" {
+			h.problem("spf", DomainProblemWarning, "%q allows any server to send for %s, use ?all or ~all", term, domain)
+		}
+	}
+	included, lookups := c.walkSpf(records[0], map[string]bool{strings.ToLower(domain): true}, h)
+	if !included {
+		h.problem("spf", DomainProblemError, "SPF record does not include %s, add include:%s before the all mechanism", c.SpfInclude, c.SpfInclude)
+	}
+	if lookups > spfLookupLimit {
+		h.problem("spf", DomainProblemError, "SPF record needs %d DNS lookups with its includes, receivers give up after %d", lookups, spfLookupLimit)
+	}
+}
+
+// walkSpf counts the DNS lookups an SPF record needs, following its include
+// and redirect targets, and reports whether it includes SpfInclude directly or
+// through one of them. seen holds the domains already followed.
+func (c *DomainHealthChecker) walkSpf(record string, seen map[string]bool, h *DomainHealth) (included bool, lookups int) {
+	for _, term := range strings.Fields(record)[1:] {
+		mechanism := strings.ToLower(strings.TrimLeft(term, "+-~?"))
+		target := ""
+		switch {
+		case strings.HasPrefix(mechanism, "include:"):
+			target = mechanism[len("include:"):]
+			lookups++
+		case strings.HasPrefix(mechanism, "redirect="):
+			target = mechanism[len("redirect="):]
+			lookups++
+		case strings.HasPrefix(mechanism, "exists:"), mechanism == "a", strings.HasPrefix(mechanism, "a:"),
+			strings.HasPrefix(mechanism, "a/"), mechanism == "mx", strings.HasPrefix(mechanism, "mx:"),
+			strings.HasPrefix(mechanism, "mx/"), mechanism == "ptr", strings.HasPrefix(mechanism, "ptr:"):
+			lookups++
+		}
+		if target == "" {
+			continue
+		}
+		if target == strings.ToLower(c.SpfInclude) {
+			// the lookups of Mandrill's own record are its concern
+			included = true
+			continue
+		}
+		// a loop or a record past the limit fails anyway
+		if seen[target] || lookups > spfLookupLimit {
+			continue
+		}
+		seen[target] = true
+		records, ok := c.lookup("spf", target, "v=spf1", h)
+		if !ok || len(records) == 0 {
+			continue
+		}
+		nestedIncluded, nestedLookups := c.walkSpf(records[0], seen, h)
+		included = included || nestedIncluded
+		lookups += nestedLookups
+	}
+	return included, lookups
+}
+
+func (c *DomainHealthChecker) checkDkim(domain string, h *DomainHealth) {
+	name := c.DkimSelector + "._domainkey." + domain
+	records, ok := c.lookup("dkim", name, "", h)
+	if !ok {
+		return
+	}
+	if len(records) == 0 {
+		h.problem("dkim", DomainProblemError, "no DKIM record found, add the TXT record for %s shown in the Mandrill sending domains settings", name)
+		return
+	}
+	h.Dkim = records[0]
+	tags := parseDnsTags(records[0])
+	if v, found := tags["v"]; found && v != "DKIM1" {
+		h.problem("dkim", DomainProblemError, "DKIM record on %s has version %q, expected DKIM1", name, v)
+	}
+	if k, found := tags["k"]; found && strings.ToLower(k) != "rsa" {
+		h.problem("dkim", DomainProblemError, "DKIM record on %s has key type %q, Mandrill signs with rsa", name, k)
+	}
+	p, found := tags["p"]
+	switch {
+	case !found:
+		h.problem("dkim", DomainProblemError, "DKIM record on %s has no public key (p=)", name)
+	case p == "":
+		h.problem("dkim", DomainProblemError, "DKIM record on %s has an empty public key, the key has been revoked", name)
+	}
+}
+
+func (c *DomainHealthChecker) checkDmarc(domain string, h *DomainHealth) {
+	name := "_dmarc." + domain
+	records, ok := c.lookup("dmarc", name, "v=DMARC1", h)
+	if !ok {
+		return
+	}
+	switch len(records) {
+	case 0:
+		h.problem("dmarc", DomainProblemWarning, "no DMARC record found, add a TXT record on %s such as: v=DMARC1; p=none", name)
+		return
+	case 1:
+	default:
+		h.problem("dmarc", DomainProblemError, "%d DMARC records found on %s, receivers ignore all of them", len(records), name)
+	}
+	h.Dmarc = records[0]
+	tags := parseDnsTags(records[0])
+	policy, found := tags["p"]
+	policy = strings.ToLower(policy)
+	h.DmarcPolicy = policy
+	switch {
+	case !found:
+		h.problem("dmarc", DomainProblemError, "DMARC record on %s has no policy (p=)", name)
+	case policy == "none":
+		h.problem("dmarc", DomainProblemWarning, "DMARC policy on %s is none, failing mail is only reported", name)
+	case policy != "quarantine" && policy != "reject":
+		h.problem("dmarc", DomainProblemError, "DMARC record on %s has an unknown policy %q", name, policy)
+	}
+}
+
+// lookup returns the TXT records on name, only keeping the ones whose version
+// tag is prefix when one is given. Errors other than a missing name are
+// reported as problems, and ok is false.
+func (c *DomainHealthChecker) lookup(record string, name string, prefix string, h *DomainHealth) (records []string, ok bool) {
+	txts, err := c.Resolver.LookupTXT(name)
+	if err != nil {
+		if dnsErr, isDnsErr := err.(*net.DNSError); isDnsErr && dnsErr.IsNotFound {
+			return nil, true
+		}
+		h.problem(record, DomainProblemError, "looking up %s failed: %v", name, err)
+		return nil, false
+	}
+	for _, txt := range txts {
+		version := strings.FieldsFunc(txt, func(r rune) bool { return r == ' ' || r == ';' })
+		if prefix == "" || (len(version) > 0 && strings.EqualFold(version[0], prefix)) {
+			records = append(records, txt)
+		}
+	}
+	return records, true
+}
+
+// parseDnsTags splits a DKIM or DMARC record into its tag=value pairs.
+func parseDnsTags(record string) map[string]string {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(record, ";") {
+		kv := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if key == "" {
+			continue
+		}
+		value := ""
+		if len(kv) == 2 {
+			value = strings.Join(strings.Fields(kv[1]), "")
+		}
+		tags[key] = value
+	}
+	return tags
+}
@@ -0,0 +1,114 @@
+package gochimp
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupTXT(name string) ([]string, error) {
+	if name == "broken.example.com" {
+		return nil, errors.New("server misbehaving")
+	}
+	txts, found := f[name]
+	if !found {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return txts, nil
+}
+
+var healthResolver = fakeResolver{
+	"good.example.com":                       {"google-site-verification=abc", "v=spf1 include:spf.mandrillapp.com ?all"},
+	"mandrill._domainkey.good.example.com":   {"v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GN"},
+	"_dmarc.good.example.com":                {"v=DMARC1; p=reject; rua=mailto:dmarc@good.example.com"},
+	"bad.example.com":                        {"v=spf1 include:_spf.google.com +all", "v=spf1 mx -all"},
+	"mandrill._domainkey.bad.example.com":    {"v=DKIM1; k=rsa; p="},
+	"_dmarc.bad.example.com":                 {"v=DMARC1; p=none"},
+	"missing.example.com":                    {"some other record"},
+	"mandrill._domainkey.broken.example.com": {"v=DKIM1; k=rsa; p=MIGf"},
+	"redirect.example.com":                   {"v=spf1 redirect=_spf.redirect.example.com"},
+	"_spf.redirect.example.com":              {"v=spf1 include:_spf.relay.example.com ~all"},
+	"_spf.relay.example.com":                 {"v=spf1 include:spf.mandrillapp.com ~all"},
+	"nested.example.com":                     {"v=spf1 include:a.nested.example.com include:spf.mandrillapp.com ~all"},
+	"a.nested.example.com":                   {"v=spf1 include:b.nested.example.com mx a include:a.nested.example.com"},
+	"b.nested.example.com":                   {"v=spf1 a mx ptr exists:%{i}.x.example.com a:y.example.com mx:z.example.com"},
+}
+
+func TestDomainHealthGood(t *testing.T) {
+	health := NewDomainHealthChecker(healthResolver).Check(Domain{Domain: "Good.Example.com."})
+	if !health.Healthy() || len(health.Problems) != 0 {
+		t.Errorf("expected no problems, got %v", health.Problems)
+	}
+	if health.DmarcPolicy != "reject" {
+		t.Errorf("expected reject policy, got %q", health.DmarcPolicy)
+	}
+	if !strings.HasPrefix(health.Spf, "v=spf1") {
+		t.Errorf("wrong SPF record picked: %q", health.Spf)
+	}
+}
+
+func TestDomainHealthProblems(t *testing.T) {
+	health := NewDomainHealthChecker(healthResolver).Check(Domain{Domain: "bad.example.com"})
+	if health.Healthy() {
+		t.Fatal("expected problems")
+	}
+	expected := []string{
+		"spf error: 2 SPF records",
+		"spf warning: \"+all\"",
+		"spf error: SPF record does not include spf.mandrillapp.com",
+		"dkim error: DKIM record on mandrill._domainkey.bad.example.com has an empty public key",
+		"dmarc warning: DMARC policy on _dmarc.bad.example.com is none",
+	}
+	if len(health.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), health.Problems)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(health.Problems[i].String(), e) {
+			t.Errorf("expected problem %q, got %q", e, health.Problems[i])
+		}
+	}
+}
+
+func TestDomainHealthMissingRecords(t *testing.T) {
+	health := NewDomainHealthChecker(healthResolver).Check(Domain{Domain: "missing.example.com"})
+	if len(health.Problems) != 3 {
+		t.Fatalf("expected 3 problems, got %v", health.Problems)
+	}
+	if health.Problems[2].Severity != DomainProblemWarning {
+		t.Errorf("a missing DMARC record should only warn, got %v", health.Problems[2])
+	}
+}
+
+func TestDomainHealthLookupFailure(t *testing.T) {
+	health := NewDomainHealthChecker(healthResolver).Check(Domain{Domain: "broken.example.com"})
+	if health.Healthy() {
+		t.Fatal("expected a lookup failure to be reported")
+	}
+	if !strings.Contains(health.Problems[0].Message, "server misbehaving") {
+		t.Errorf("expected resolver error in problem, got %v", health.Problems[0])
+	}
+}
+
+func TestDomainHealthSpfIncludes(t *testing.T) {
+	checker := NewDomainHealthChecker(healthResolver)
+	health := checker.Check(Domain{Domain: "redirect.example.com"})
+	for _, problem := range health.Problems {
+		if problem.Record == "spf" {
+			t.Errorf("Mandrill is included through the redirect, got %v", problem)
+		}
+	}
+	// 2 lookups on the domain, 4 on the first include and 6 on the one it includes
+	health = checker.Check(Domain{Domain: "nested.example.com"})
+	var spf []string
+	for _, problem := range health.Problems {
+		if problem.Record == "spf" {
+			spf = append(spf, problem.String())
+		}
+	}
+	if len(spf) != 1 || !strings.HasPrefix(spf[0], "spf error: SPF record needs 12 DNS lookups") {
+		t.Errorf("expected the nested lookups to be counted, got %v", spf)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// the defaults of an EventPoller
+const (
+	DefaultPollWindow = 3 * 24 * time.Hour
+	DefaultPollLimit  = 1000
+	// MinPollSlice is the shortest window searched when splitting a search
+	// that hit its limit
+	MinPollSlice = time.Minute
+)
+
+// PolledMessage is what an EventPoller saw of a message on its last poll.
+type PolledMessage struct {
+	Ts         time.Time `json:"ts"`
+	State      string    `json:"state"`
+	Opens      int       `json:"opens"`
+	Clicks     int       `json:"clicks"`
+	SMTPEvents int       `json:"smtp_events"`
+}
+
+// EventCheckpoint is the state of an EventPoller, saved after each poll.
+type EventCheckpoint struct {
+	PolledAt time.Time                `json:"polled_at"`
+	Messages map[string]PolledMessage `json:"messages"`
+}
+
+// CheckpointStore keeps the checkpoint of an EventPoller across restarts.
+type CheckpointStore interface {
+	// Load returns the last checkpoint saved, a zero one when there is none
+	Load() (EventCheckpoint, error)
+	Save(checkpoint EventCheckpoint) error
+}
+
+// FileCheckpointStore is a CheckpointStore keeping the checkpoint as JSON in a
+// file, replaced whole on each save.
+type FileCheckpointStore struct {
+	Path string
+}
+
+func NewFileCheckpointStore(path string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return &FileCheckpointStore{Path: path}, nil
+}
+
+func (s *FileCheckpointStore) Load() (EventCheckpoint, error) {
+	var checkpoint EventCheckpoint
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+	err = json.Unmarshal(b, &checkpoint)
+	return checkpoint, err
+}
+
+func (s *FileCheckpointStore) Save(checkpoint EventCheckpoint) error {
+	b, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	// write aside and rename, so that a crash leaves the previous checkpoint
+	tmp := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// EventPoller turns MessageSearch results into webhook events, for
+// environments webhooks cannot reach. Each poll searches the messages of the
+// last Window, compares their state, opens and clicks to the previous poll
+// and dispatches an event for each change, as a webhook would have posted
+// it. Events carry no Raw, and opens and clicks no parsed user agent or location.
+//
+// The checkpoint is saved once the events of a poll are dispatched, so a
+// restart resumes without duplicates. A poll whose dispatch fails is done
+// again, in full, by the next one, and so is a poll whose checkpoint fails to
+// save: delivery is at least once, and callbacks should tolerate an event
+// dispatched twice.
+//
+// A search that returns Search.Limit messages is split until each part
+// returns fewer, so that no message is left out.
+type EventPoller struct {
+	API        *MandrillAPI
+	Dispatcher WebhookDispatcher
+	Checkpoint CheckpointStore
+	// Search narrows the messages followed, its dates are set by each poll
+	Search SearchRequest
+	// Window is how far back messages are followed, 0 means DefaultPollWindow
+	Window time.Duration
+	// Backfill dispatches events for the messages found by the first poll,
+	// which otherwise only records them
+	Backfill bool
+
+	now func() time.Time
+}
+
+// NewEventPoller returns a poller dispatching to dispatcher, such as a WebhookHandler.
+func NewEventPoller(api *MandrillAPI, dispatcher WebhookDispatcher, checkpoint CheckpointStore) *EventPoller {
+	return &EventPoller{API: api, Dispatcher: dispatcher, Checkpoint: checkpoint}
+}
+
+// Poll searches the messages once, dispatches the events found and saves the
+// checkpoint. It returns the number of events dispatched.
+func (p *EventPoller) Poll() (int, error) {
+	if p.API == nil || p.Dispatcher == nil || p.Checkpoint == nil {
+		return 0, errors.New("EventPoller needs an API, a Dispatcher and a Checkpoint")
+	}
+	now := time.Now()
+	if p.now != nil {
+		now = p.now()
+	}
+	window := p.Window
+	if window <= 0 {
+		window = DefaultPollWindow
+	}
+	from := now.Add(-window)
+	checkpoint, err := p.Checkpoint.Load()
+	if err != nil {
+		return 0, err
+	}
+	search := p.Search
+	if search.Query == "" {
+		search.Query = "*"
+	}
+	if search.Limit <= 0 {
+		search.Limit = DefaultPollLimit
+	}
+	messages, err := p.search(search, from, now)
+	if err != nil {
+		return 0, err
+	}
+	first := checkpoint.PolledAt.IsZero()
+	seen := make(map[string]PolledMessage, len(messages))
+	var events []WebhookEvent
+	for _, message := range messages {
+		previous, found := checkpoint.Messages[message.Id]
+		current := PolledMessage{Ts: unixDuration(message.Timestamp), State: message.State,
+			Opens: message.Opens, Clicks: message.Clicks, SMTPEvents: len(message.SMTPEvents)}
+		seen[message.Id] = current
+		if !found && first && !p.Backfill {
+			continue
+		}
+		events = append(events, diffSearchResponse(message, previous)...)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Ts.Before(events[j].Ts.Time) })
+	if len(events) > 0 {
+		if err := p.Dispatcher.Dispatch(events); err != nil {
+			return 0, err
+		}
+	}
+	// keep the messages the search no longer returns while they are in the window
+	for id, message := range checkpoint.Messages {
+		if _, found := seen[id]; !found && !message.Ts.Before(from) {
+			seen[id] = message
+		}
+	}
+	return len(events), p.Checkpoint.Save(EventCheckpoint{PolledAt: now, Messages: seen})
+}
+
+// search returns the messages from from to to. A search that returns Limit
+// messages may have left some out, so its window is split in two and each
+// half is searched again, down to MinPollSlice.
+func (p *EventPoller) search(search SearchRequest, from time.Time, to time.Time) ([]SearchResponse, error) {
+	search.DateFrom, search.DateTo = APITime{from}, APITime{to}
+	messages, err := p.API.MessageSearch(search)
+	if err != nil || len(messages) < search.Limit {
+		return messages, err
+	}
+	if to.Sub(from) <= MinPollSlice {
+		return nil, fmt.Errorf("more than %d messages between %s and %s, raise the search limit",
+			search.Limit, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	middle := from.Add(to.Sub(from) / 2)
+	messages, err = p.search(search, from, middle)
+	if err != nil {
+		return nil, err
+	}
+	later, err := p.search(search, middle, to)
+	if err != nil {
+		return nil, err
+	}
+	// the halves share their bound
+	found := make(map[string]bool, len(messages))
+	for _, message := range messages {
+		found[message.Id] = true
+	}
+	for _, message := range later {
+		if !found[message.Id] {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// Run polls every interval until stop is closed.
+func (p *EventPoller) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.Poll()
+		}
+	}
+}
+
+// the webhook event of each message state
+var searchStateEvents = map[string]string{
+	"sent":         WebhookEventSend,
+	"bounced":      WebhookEventHardBounce,
+	"soft-bounced": WebhookEventSoftBounce,
+	"rejected":     WebhookEventReject,
+	"spam":         WebhookEventSpam,
+	"unsub":        WebhookEventUnsub,
+}
+
+// diffSearchResponse returns the events of what changed in a message since
+// it was seen as previous.
+func diffSearchResponse(m SearchResponse, previous PolledMessage) []WebhookEvent {
+	msg := &WebhookMessage{Ts: TS{unixDuration(m.Timestamp)}, Id: m.Id, Subject: m.Subject, Email: m.Email,
+		Sender: m.Sender, Tags: m.Tags, State: m.State, Diag: m.Diag, Opens: m.OpensDetail, Clicks: m.ClicksDetail,
+		SMTPEvents: m.SMTPEvents, Resends: m.Resends}
+	if template, ok := m.Template.(string); ok {
+		msg.Template = template
+	}
+	if len(m.Metadata) > 0 {
+		msg.Metadata = make(map[string]interface{}, len(m.Metadata))
+		for key, value := range m.Metadata {
+			msg.Metadata[key] = value
+		}
+	}
+	event := func(eventType string, ts time.Time) WebhookEvent {
+		return WebhookEvent{Event: eventType, Ts: TS{ts}, Id: m.Id, Msg: msg}
+	}
+	var events []WebhookEvent
+	for i := previous.SMTPEvents; i < len(m.SMTPEvents); i++ {
+		if m.SMTPEvents[i].Type == "deferred" {
+			events = append(events, event(WebhookEventDeferral, unixDuration(m.SMTPEvents[i].Timestamp)))
+		}
+	}
+	if eventType, found := searchStateEvents[m.State]; found && m.State != previous.State {
+		// a message first seen already opened or reported was sent before
+		if previous.State == "" && eventType != WebhookEventSend && eventType != WebhookEventReject &&
+			eventType != WebhookEventHardBounce && eventType != WebhookEventSoftBounce {
+			events = append(events, event(WebhookEventSend, msg.Ts.Time))
+		}
+		events = append(events, event(eventType, msg.Ts.Time))
+	}
+	for i := previous.Opens; i < m.Opens; i++ {
+		open := event(WebhookEventOpen, msg.Ts.Time)
+		if i < len(m.OpensDetail) {
+			detail := m.OpensDetail[i]
+			open.Ts, open.IP, open.UserAgent = TS{unixDuration(detail.Timestamp)}, detail.IP, detail.UserAgent
+		}
+		events = append(events, open)
+	}
+	for i := previous.Clicks; i < m.Clicks; i++ {
+		click := event(WebhookEventClick, msg.Ts.Time)
+		if i < len(m.ClicksDetail) {
+			detail := m.ClicksDetail[i]
+			click.Ts, click.IP, click.UserAgent, click.Url = TS{unixDuration(detail.Timestamp)}, detail.IP, detail.UserAgent, detail.Url
+		}
+		events = append(events, click)
+	}
+	return events
+}
+
+// unixDuration converts the ts of search results, unix seconds decoded into a
+// time.Duration, to a time.
+func unixDuration(ts time.Duration) time.Time {
+	return time.Unix(int64(ts), 0)
+}
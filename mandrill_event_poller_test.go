@@ -0,0 +1,201 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventPoller(t *testing.T) {
+	var results string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(results))
+	}))
+	defer srv.Close()
+	store, err := NewFileCheckpointStore(filepath.Join(t.TempDir(), "poller", "checkpoint.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var seen []string
+	var failing error
+	h := NewWebhookHandler("", "")
+	h.OnAny(func(event WebhookEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing != nil {
+			return failing
+		}
+		seen = append(seen, fmt.Sprintf("%s %s %s", event.Id, event.Event, event.Url))
+		return nil
+	})
+	now := time.Unix(1365100000, 0)
+	newPoller := func() *EventPoller {
+		p := NewEventPoller(&MandrillAPI{endpoint: srv.URL}, h, store)
+		p.now = func() time.Time { return now }
+		return p
+	}
+	poll := func(p *EventPoller) string {
+		seen = nil
+		if _, err := p.Poll(); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(seen)
+		return strings.Join(seen, ",")
+	}
+
+	// the first poll only records what is there
+	results = `[{"ts":1365090000,"_id":"a","email":"a@example.com","state":"sent","opens":1,"clicks":0,
+		"opens_detail":[{"ts":1365090100,"ip":"203.0.113.7"}]},
+		{"ts":1365090000,"_id":"b","email":"b@example.com","state":"sent","opens":0,"clicks":0}]`
+	p := newPoller()
+	if got := poll(p); got != "" {
+		t.Errorf("first poll dispatched %s", got)
+	}
+
+	results = `[{"ts":1365090000,"_id":"a","email":"a@example.com","state":"sent","opens":2,"clicks":1,
+		"opens_detail":[{"ts":1365090100,"ip":"203.0.113.7"},{"ts":1365095000,"ip":"203.0.113.8"}],
+		"clicks_detail":[{"ts":1365095001,"url":"https://example.org/x"}]},
+		{"ts":1365090000,"_id":"b","email":"b@example.com","state":"bounced","diag":"smtp;550","opens":0,"clicks":0},
+		{"ts":1365099000,"_id":"c","email":"c@example.com","state":"sent","opens":0,"clicks":0,
+		"smtp_events":[{"ts":1365099001,"type":"deferred","diag":"451 busy"},{"ts":1365099500,"type":"sent"}]}]`
+	now = now.Add(time.Minute)
+	failing = errors.New("consumer down")
+	if _, err := p.Poll(); err == nil {
+		t.Fatal("expected the dispatch error")
+	}
+	failing = nil
+	expected := "a click https://example.org/x,a open ,b hard_bounce ,c deferral ,c send "
+	if got := poll(p); got != expected {
+		t.Errorf("got %s", got)
+	}
+
+	// a restarted poller resumes from the checkpoint
+	now = now.Add(time.Minute)
+	if got := poll(newPoller()); got != "" {
+		t.Errorf("restart dispatched %s", got)
+	}
+	checkpoint, err := store.Load()
+	if err != nil || len(checkpoint.Messages) != 3 || checkpoint.Messages["a"].Opens != 2 {
+		t.Errorf("wrong checkpoint %+v %v", checkpoint, err)
+	}
+
+	// messages leave the checkpoint with the window
+	results = `[]`
+	now = time.Unix(1365095000, 0).Add(DefaultPollWindow)
+	poll(newPoller())
+	if checkpoint, _ := store.Load(); len(checkpoint.Messages) != 1 {
+		t.Errorf("wrong checkpoint %+v", checkpoint)
+	}
+}
+
+func TestEventPollerLimit(t *testing.T) {
+	// sent holds the ts of the messages the fake search knows
+	sent := []int64{1365090000, 1365090000, 1365093000, 1365096000}
+	var searches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			DateFrom time.Time `json:"date_from"`
+			DateTo   time.Time `json:"date_to"`
+			Limit    int       `json:"limit"`
+		}
+		json.NewDecoder(r.Body).Decode(&params)
+		searches++
+		var results []string
+		for i, ts := range sent {
+			if ts >= params.DateFrom.Unix() && ts <= params.DateTo.Unix() && len(results) < params.Limit {
+				results = append(results, fmt.Sprintf(`{"ts":%d,"_id":"%d","state":"sent"}`, ts, i))
+			}
+		}
+		w.Write([]byte("[" + strings.Join(results, ",") + "]"))
+	}))
+	defer srv.Close()
+	var mu sync.Mutex
+	var seen []string
+	h := NewWebhookHandler("", "")
+	h.OnAny(func(event WebhookEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, event.Id)
+		return nil
+	})
+	store, _ := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	p := NewEventPoller(&MandrillAPI{endpoint: srv.URL}, h, store)
+	p.now = func() time.Time { return time.Unix(1365100000, 0) }
+	p.Backfill = true
+	p.Search.Limit = 3
+	if n, err := p.Poll(); err != nil || n != 4 || searches < 3 {
+		t.Errorf("dispatched %d %v after %d searches: %v", n, seen, searches, err)
+	}
+
+	// messages sent in the same second cannot be split apart
+	p.Search.Limit = 2
+	checkpoint, _ := store.Load()
+	seen = nil
+	if _, err := p.Poll(); err == nil || !strings.Contains(err.Error(), "more than 2 messages") || len(seen) != 0 {
+		t.Errorf("expected the limit error, got %v %v", seen, err)
+	}
+	if saved, _ := store.Load(); !saved.PolledAt.Equal(checkpoint.PolledAt) {
+		t.Errorf("checkpoint moved past the missing messages %+v", saved)
+	}
+}
+
+// failingCheckpointStore is a CheckpointStore whose saves fail.
+type failingCheckpointStore struct {
+	checkpoint EventCheckpoint
+}
+
+func (s *failingCheckpointStore) Load() (EventCheckpoint, error) {
+	return s.checkpoint, nil
+}
+
+func (s *failingCheckpointStore) Save(checkpoint EventCheckpoint) error {
+	return errors.New("disk full")
+}
+
+func TestEventPollerSaveFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"ts":1365090000,"_id":"a","state":"bounced","diag":"smtp;550"}]`))
+	}))
+	defer srv.Close()
+	var mu sync.Mutex
+	var seen []string
+	h := NewWebhookHandler("", "")
+	h.OnAny(func(event WebhookEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, event.Id+" "+event.Event)
+		return nil
+	})
+	store := &failingCheckpointStore{EventCheckpoint{PolledAt: time.Unix(1365000000, 0)}}
+	p := NewEventPoller(&MandrillAPI{endpoint: srv.URL}, h, store)
+	p.now = func() time.Time { return time.Unix(1365100000, 0) }
+	for i := 0; i < 2; i++ {
+		if _, err := p.Poll(); err == nil || err.Error() != "disk full" {
+			t.Fatalf("expected the save error, got %v", err)
+		}
+	}
+	// the events of a poll whose checkpoint is lost are dispatched again
+	if strings.Join(seen, ",") != "a hard_bounce,a hard_bounce" {
+		t.Errorf("got %v", seen)
+	}
+}
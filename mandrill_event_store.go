@@ -0,0 +1,273 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StoredBatch is a webhook batch as it was received.
+type StoredBatch struct {
+	ReceivedAt time.Time `json:"received_at"`
+	// Url is the webhook url the batch was posted to
+	Url string `json:"url"`
+	// Events is the mandrill_events field of the post
+	Events json.RawMessage `json:"events"`
+}
+
+// EventStore keeps the webhook batches received. Set it on
+// WebhookHandler.Store or InboundHandler.Store to save every verified batch
+// before it is dispatched, so that events can be replayed with ReplayEvents.
+type EventStore interface {
+	// Append stores a batch after the ones already stored.
+	Append(batch StoredBatch) error
+	// Scan calls fn for every stored batch, oldest first, stopping at the
+	// first error fn returns.
+	Scan(fn func(batch StoredBatch) error) error
+}
+
+// EventFilter selects the events to replay. Zero fields select everything.
+type EventFilter struct {
+	// Since and Until bound the time of the events, Until excluded
+	Since time.Time
+	Until time.Time
+	// Types are the event types to replay, WebhookEvent constants
+	Types []string
+}
+
+// Match reports whether the filter selects an event.
+func (f EventFilter) Match(event WebhookEvent) bool {
+	if !f.Since.IsZero() && event.Ts.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !event.Ts.Before(f.Until) {
+		return false
+	}
+	return len(f.Types) == 0 || hasString(f.Types, event.Event)
+}
+
+// WebhookDispatcher hands events to their callbacks, WebhookHandler and
+// InboundHandler are dispatchers.
+type WebhookDispatcher interface {
+	Dispatch(events []WebhookEvent) error
+}
+
+// ReplayEvents dispatches again the stored events the filter selects, one
+// stored batch at a time. It stops at the first batch that fails and returns
+// the number of events dispatched before it.
+func ReplayEvents(store EventStore, filter EventFilter, dispatcher WebhookDispatcher) (int, error) {
+	replayed := 0
+	err := store.Scan(func(batch StoredBatch) error {
+		events, err := ParseWebhookEvents(string(batch.Events))
+		if err != nil {
+			return fmt.Errorf("batch received at %s: %v", batch.ReceivedAt.Format(time.RFC3339), err)
+		}
+		var selected []WebhookEvent
+		for _, event := range events {
+			if filter.Match(event) {
+				selected = append(selected, event)
+			}
+		}
+		if len(selected) == 0 {
+			return nil
+		}
+		if err := dispatcher.Dispatch(selected); err != nil {
+			return fmt.Errorf("batch received at %s: %v", batch.ReceivedAt.Format(time.RFC3339), err)
+		}
+		replayed += len(selected)
+		return nil
+	})
+	return replayed, err
+}
+
+// storeBatch saves the batch of a verified post in store, when one is set.
+func storeBatch(store EventStore, webhookURL string, mandrillEvents string) error {
+	if store == nil {
+		return nil
+	}
+	return store.Append(StoredBatch{ReceivedAt: time.Now().UTC(), Url: webhookURL, Events: json.RawMessage(mandrillEvents)})
+}
+
+// FileEventStore is an EventStore that appends batches as JSON lines to a file.
+//
+// Mandrill posts a batch again when a post fails, so a batch already stored
+// for the same url is not appended twice and is replayed once. The digests of
+// the stored batches are read from the file on the first Append.
+type FileEventStore struct {
+	Path string
+	mu   sync.Mutex
+	// the digests of the batches stored, by batchDigest
+	stored map[[sha256.Size]byte]bool
+}
+
+// NewFileEventStore returns a store writing to path, creating its directory if needed.
+func NewFileEventStore(path string) (*FileEventStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return &FileEventStore{Path: path}, nil
+}
+
+func (s *FileEventStore) Append(batch StoredBatch) error {
+	if !json.Valid(batch.Events) {
+		return errors.New("events must be valid json")
+	}
+	if batch.ReceivedAt.IsZero() {
+		batch.ReceivedAt = time.Now().UTC()
+	}
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stored == nil {
+		stored := make(map[[sha256.Size]byte]bool)
+		err := s.scan(-1, func(previous StoredBatch) error {
+			stored[batchDigest(previous)] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.stored = stored
+	}
+	digest := batchDigest(batch)
+	if s.stored[digest] {
+		return nil
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err := truncatePartialLine(f); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	// the batch is acknowledged once dispatched, it must be on disk by then
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.stored[digest] = true
+	return nil
+}
+
+// batchDigest identifies the content of a batch, whatever its spacing and
+// when it was received.
+func batchDigest(batch StoredBatch) [sha256.Size]byte {
+	var events bytes.Buffer
+	if err := json.Compact(&events, batch.Events); err != nil {
+		events.Reset()
+		events.Write(batch.Events)
+	}
+	return sha256.Sum256([]byte(batch.Url + "\n" + events.String()))
+}
+
+// truncatePartialLine drops the end of a write that was cut short, so that the
+// next line starts on a line of its own, and leaves f at the end of the file.
+func truncatePartialLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, size-1); err != nil {
+			return err
+		}
+		if last[0] != '\n' {
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				return err
+			}
+			size = int64(bytes.LastIndexByte(data, '\n') + 1)
+			if err := f.Truncate(size); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = f.Seek(size, io.SeekStart)
+	return err
+}
+
+// Scan reads the batches back. It reads the file as it was when called, so
+// batches appended meanwhile are not seen. A last line without a newline,
+// left by a write that was cut short, is skipped.
+func (s *FileEventStore) Scan(fn func(batch StoredBatch) error) error {
+	// Append only writes past the size under the lock, fn may take long and
+	// must not hold it
+	s.mu.Lock()
+	info, err := os.Stat(s.Path)
+	s.mu.Unlock()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.scan(info.Size(), fn)
+}
+
+// scan calls fn for the batches in the first size bytes of the file, the
+// whole file when size is negative.
+func (s *FileEventStore) scan(size int64, fn func(batch StoredBatch) error) error {
+	f, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if size >= 0 {
+		in = io.LimitReader(f, size)
+	}
+	// lines are not bounded, inbound batches carry whole messages
+	r := bufio.NewReader(in)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var batch StoredBatch
+		if err := json.Unmarshal(line, &batch); err != nil {
+			return fmt.Errorf("%s:%d: %v", s.Path, n, err)
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventStoreReplay(t *testing.T) {
+	const webhookURL = "https://example.com/mandrill"
+	store, err := NewFileEventStore(filepath.Join(t.TempDir(), "events", "mandrill.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewWebhookHandler("secret", webhookURL)
+	h.Store = store
+	h.OnAny(func(event WebhookEvent) error {
+		return errors.New("consumer down")
+	})
+	if w := postWebhook(h, "secret", webhookURL, webhookBatch); w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if w := postWebhook(h, "wrong", webhookURL, `[{"event":"send","ts":1365109999}]`); w.Code != http.StatusForbidden {
+		t.Fatalf("bad signature got status %d", w.Code)
+	}
+	// writes cut short are dropped by the next append, or skipped at the end
+	f, err := os.OpenFile(store.Path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"received_at":"2013-04-04T00:00:00Z","events":[{"ev`)
+	f.Close()
+	if err := store.Append(StoredBatch{Url: webhookURL, Events: []byte(`[{"event":"send","ts":1365100000}]`)}); err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.OpenFile(store.Path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"received_at":"2013-04-04T00:00:00Z","events":[{"ev`)
+	f.Close()
+
+	var mu sync.Mutex
+	var seen []string
+	replay := NewWebhookHandler("", "")
+	replay.OnAny(func(event WebhookEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, event.Event)
+		return nil
+	})
+	n, err := ReplayEvents(store, EventFilter{}, replay)
+	sort.Strings(seen)
+	if err != nil || n != 5 || strings.Join(seen, ",") != "blacklist,click,hard_bounce,inbound,send" {
+		t.Errorf("replayed %d %v: %v", n, seen, err)
+	}
+
+	seen = nil
+	n, err = ReplayEvents(store, EventFilter{Since: time.Unix(1365110000, 0), Types: []string{WebhookEventClick, WebhookEventHardBounce}}, replay)
+	if err != nil || n != 1 || strings.Join(seen, ",") != "click" {
+		t.Errorf("replayed %d %v: %v", n, seen, err)
+	}
+	n, err = ReplayEvents(store, EventFilter{Until: time.Unix(1365109999, 0)}, replay)
+	if err != nil || n != 1 {
+		t.Errorf("replayed %d: %v", n, err)
+	}
+
+	inbound := NewInboundHandler("", "")
+	inbound.Handle("*", func(message InboundMessage) error {
+		return errors.New("still down")
+	})
+	if _, err := ReplayEvents(store, EventFilter{}, inbound); err == nil || !strings.Contains(err.Error(), "still down") {
+		t.Errorf("expected the dispatch error, got %v", err)
+	}
+}
+
+func TestEventStoreRetriedBatch(t *testing.T) {
+	const webhookURL = "https://example.com/mandrill"
+	path := filepath.Join(t.TempDir(), "mandrill.jsonl")
+	store, _ := NewFileEventStore(path)
+	h := NewWebhookHandler("secret", webhookURL)
+	h.Store = store
+	failing := true
+	h.OnAny(func(event WebhookEvent) error {
+		if failing {
+			return errors.New("consumer down")
+		}
+		return nil
+	})
+	// Mandrill posts the batch again after the 500
+	if w := postWebhook(h, "secret", webhookURL, webhookBatch); w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d", w.Code)
+	}
+	failing = false
+	if w := postWebhook(h, "secret", webhookURL, webhookBatch); w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	// a restarted store knows the batches of the file, whatever their spacing
+	restarted, _ := NewFileEventStore(path)
+	if err := restarted.Append(StoredBatch{Url: webhookURL, Events: []byte(`[ {"event":"send", "ts":1365100000} ]`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Append(StoredBatch{Url: webhookURL, Events: []byte(`[{"event":"send","ts":1365100000}]`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Append(StoredBatch{Url: "https://example.com/other", Events: []byte(`[{"event":"send","ts":1365100000}]`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// batches appended while scanning are left to the next scan
+	batches := 0
+	err := restarted.Scan(func(batch StoredBatch) error {
+		batches++
+		return restarted.Append(StoredBatch{Url: webhookURL, Events: []byte(`[{"event":"send","ts":1365100001}]`)})
+	})
+	if err != nil || batches != 3 {
+		t.Errorf("scanned %d batches: %v", batches, err)
+	}
+	batches = 0
+	restarted.Scan(func(batch StoredBatch) error {
+		batches++
+		return nil
+	})
+	if batches != 4 {
+		t.Errorf("stored %d batches", batches)
+	}
+}
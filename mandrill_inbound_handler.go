@@ -0,0 +1,230 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// see https://mandrill.zendesk.com/hc/en-us/articles/205583197-Inbound-Email-Processing-Overview
+
+// InboundMessage is a message received on an inbound domain, the msg of an
+// inbound webhook event.
+type InboundMessage struct {
+	// RawMsg is the full MIME message as received
+	RawMsg    string                 `json:"raw_msg"`
+	Headers   map[string]interface{} `json:"headers"`
+	Text      string                 `json:"text"`
+	Html      string                 `json:"html"`
+	FromEmail string                 `json:"from_email"`
+	FromName  string                 `json:"from_name"`
+	// To holds the [email, name] pairs of the To header
+	To [][]string `json:"to"`
+	// Email is the address the message was delivered to, the one matching the route
+	Email   string   `json:"email"`
+	Subject string   `json:"subject"`
+	Tags    []string `json:"tags"`
+	Sender  string   `json:"sender"`
+	// Attachments are keyed by file name
+	Attachments map[string]InboundAttachment `json:"attachments"`
+	// Images are the inline images, keyed by content id
+	Images     map[string]InboundAttachment `json:"images"`
+	SpamReport InboundSpamReport            `json:"spam_report"`
+	Dkim       InboundDkim                  `json:"dkim"`
+	Spf        InboundSpf                   `json:"spf"`
+}
+
+// Header returns the first value of a header of the message, matching its name
+// case insensitively.
+func (m InboundMessage) Header(name string) string {
+	for key, value := range m.Headers {
+		if !strings.EqualFold(key, name) {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			return v
+		case []string:
+			if len(v) > 0 {
+				return v[0]
+			}
+		case []interface{}:
+			if len(v) > 0 {
+				s, _ := v[0].(string)
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// InboundAttachment is an attachment or an inline image of an inbound message.
+type InboundAttachment struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	// Base64 reports whether Content is base64 encoded, binary files are
+	Base64 bool `json:"base64"`
+	// Data is the decoded Content
+	Data []byte `json:"-"`
+}
+
+func (a *InboundAttachment) UnmarshalJSON(data []byte) error {
+	type attachment InboundAttachment
+	if err := json.Unmarshal(data, (*attachment)(a)); err != nil {
+		return err
+	}
+	if !a.Base64 {
+		a.Data = []byte(a.Content)
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(a.Content)
+	if err != nil {
+		return fmt.Errorf("decoding attachment %s: %v", a.Name, err)
+	}
+	a.Data = decoded
+	return nil
+}
+
+type InboundSpamReport struct {
+	Score        float64    `json:"score"`
+	MatchedRules []SpamRule `json:"matched_rules"`
+}
+
+// SpamRule is a SpamAssassin rule an inbound message matched.
+type SpamRule struct {
+	Name        string  `json:"name"`
+	Score       float64 `json:"score"`
+	Description string  `json:"description"`
+}
+
+type InboundDkim struct {
+	Signed bool `json:"signed"`
+	Valid  bool `json:"valid"`
+}
+
+type InboundSpf struct {
+	// Result is one of pass, neutral, fail, softfail, temperror, permerror, none
+	Result string `json:"result"`
+	Detail string `json:"detail"`
+}
+
+// MatchRoutePattern reports whether an address matches the pattern of an
+// inbound Route. A pattern without '@' matches the mailbox, the part of the
+// address before the '@', and '*' matches any run of characters. Matching is
+// case insensitive.
+func MatchRoutePattern(pattern string, email string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !strings.Contains(pattern, "@") {
+		if at := strings.LastIndexByte(email, '@'); at >= 0 {
+			email = email[:at]
+		}
+	}
+	return matchWildcard(pattern, email)
+}
+
+// matchWildcard matches s against a pattern where '*' matches any run of characters.
+func matchWildcard(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// InboundFunc handles an inbound message. Returning an error makes the batch
+// fail, so that Mandrill posts it again later.
+type InboundFunc func(message InboundMessage) error
+
+type inboundRoute struct {
+	pattern string
+	fn      InboundFunc
+}
+
+// InboundHandler is an http.Handler receiving the posts of inbound routes. It
+// hands each message to the first handler whose pattern, in the syntax of
+// RouteAdd, matches the address the message was delivered to.
+//
+// Handlers must be registered before the handler serves requests.
+type InboundHandler struct {
+	// AuthKey and URL verify the signature of posts, as in WebhookHandler
+	AuthKey string
+	URL     string
+	// SkipVerification accepts posts without checking their signature
+	SkipVerification bool
+	// Concurrency is the number of messages handled at once, 0 means DefaultWebhookConcurrency
+	Concurrency int
+	// Store, when set, saves every verified batch before it is dispatched
+	Store EventStore
+	// Unmatched, when set, handles the messages no pattern matches, they are
+	// dropped otherwise
+	Unmatched InboundFunc
+
+	routes []inboundRoute
+}
+
+// NewInboundHandler returns a handler for the route added with url, authKey is
+// the auth key of the route webhook.
+func NewInboundHandler(authKey string, url string) *InboundHandler {
+	return &InboundHandler{AuthKey: authKey, URL: url}
+}
+
+// Handle registers fn for the messages delivered to addresses matching pattern.
+func (h *InboundHandler) Handle(pattern string, fn InboundFunc) {
+	h.routes = append(h.routes, inboundRoute{pattern, fn})
+}
+
+func (h *InboundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	webhook := &WebhookHandler{AuthKey: h.AuthKey, URL: h.URL, Concurrency: h.Concurrency, Store: h.Store,
+		SkipVerification: h.SkipVerification}
+	webhook.OnInbound(h.route)
+	webhook.ServeHTTP(w, r)
+}
+
+// Dispatch hands the messages of inbound events to their handler, other
+// events are ignored.
+func (h *InboundHandler) Dispatch(events []WebhookEvent) error {
+	webhook := &WebhookHandler{Concurrency: h.Concurrency}
+	webhook.OnInbound(h.route)
+	return webhook.Dispatch(events)
+}
+
+func (h *InboundHandler) route(event WebhookEvent) error {
+	if event.Inbound == nil {
+		return nil
+	}
+	for _, route := range h.routes {
+		if MatchRoutePattern(route.pattern, event.Inbound.Email) {
+			return route.fn(*event.Inbound)
+		}
+	}
+	if h.Unmatched != nil {
+		return h.Unmatched(*event.Inbound)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+const inboundBatch = `[
+{"event":"inbound","ts":1365109999,"msg":{
+ "raw_msg":"Received: from mail.example.org\nSubject: Invoice\n\nSee attached",
+ "headers":{"Subject":"Invoice","Received":["from a","from b"]},
+ "email":"billing+42@example.com","from_email":"client@example.org","subject":"Invoice",
+ "to":[["billing+42@example.com","Billing"]],"tags":[],"text":"See attached",
+ "attachments":{"invoice.pdf":{"name":"invoice.pdf","type":"application/pdf","content":"JVBERi0xLjQ=","base64":true},
+  "notes.txt":{"name":"notes.txt","type":"text/plain","content":"thanks","base64":false}},
+ "images":{"logo":{"name":"logo","type":"image/png","content":"iVBORw==","base64":true}},
+ "spam_report":{"score":1.2,"matched_rules":[{"name":"HTML_MESSAGE","score":0.001,"description":"HTML included in message"}]},
+ "dkim":{"signed":true,"valid":true},"spf":{"result":"pass","detail":"sender SPF authorized"}}},
+{"event":"inbound","ts":1365109999,"msg":{"email":"support@example.com","subject":"Help"}},
+{"event":"inbound","ts":1365109999,"msg":{"email":"nobody@example.com","subject":"Lost"}}
+]`
+
+func TestMatchRoutePattern(t *testing.T) {
+	tests := []struct {
+		pattern, email string
+		match          bool
+	}{
+		{"*", "anyone@example.com", true},
+		{"support", "Support@example.com", true},
+		{"support", "support-team@example.com", false},
+		{"billing+*", "billing+42@example.com", true},
+		{"*-reply", "ticket-12-reply@example.com", true},
+		{"*-reply", "ticket-12-replies@example.com", false},
+		{"a*b*c", "axxbyyc@example.com", true},
+		{"*@example.com", "x@example.com", true},
+		{"*@example.com", "x@example.org", false},
+	}
+	for _, test := range tests {
+		if got := MatchRoutePattern(test.pattern, test.email); got != test.match {
+			t.Errorf("MatchRoutePattern(%q, %q) = %v", test.pattern, test.email, got)
+		}
+	}
+}
+
+func TestInboundHandler(t *testing.T) {
+	const webhookURL = "https://example.com/inbound"
+	h := NewInboundHandler("secret", webhookURL)
+	var mu sync.Mutex
+	var billing InboundMessage
+	var unmatched []string
+	h.Handle("billing+*", func(message InboundMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		billing = message
+		return nil
+	})
+	h.Handle("support", func(message InboundMessage) error {
+		return errors.New("ticket system down")
+	})
+	h.Unmatched = func(message InboundMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		unmatched = append(unmatched, message.Email)
+		return nil
+	}
+	w := postWebhook(h, "secret", webhookURL, inboundBatch)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("a failing handler got status %d", w.Code)
+	}
+	if billing.Subject != "Invoice" || string(billing.Attachments["invoice.pdf"].Data) != "%PDF-1.4" ||
+		string(billing.Attachments["notes.txt"].Data) != "thanks" || len(billing.Images["logo"].Data) != 4 {
+		t.Errorf("wrong attachments %+v", billing.Attachments)
+	}
+	if billing.SpamReport.Score != 1.2 || billing.SpamReport.MatchedRules[0].Name != "HTML_MESSAGE" || !billing.Dkim.Valid || billing.Spf.Result != "pass" {
+		t.Errorf("wrong checks %+v %+v %+v", billing.SpamReport, billing.Dkim, billing.Spf)
+	}
+	if billing.Header("subject") != "Invoice" || billing.Header("received") != "from a" {
+		t.Errorf("wrong headers %v", billing.Headers)
+	}
+	if len(unmatched) != 1 || unmatched[0] != "nobody@example.com" {
+		t.Errorf("wrong unmatched %v", unmatched)
+	}
+
+	// a handler missing its key refuses posts, unless told to skip the check
+	unsigned := NewInboundHandler("", webhookURL)
+	unsigned.Unmatched = h.Unmatched
+	unmatched = nil
+	if w := postWebhook(unsigned, "", webhookURL, inboundBatch); w.Code != http.StatusForbidden || len(unmatched) != 0 {
+		t.Errorf("a handler without key got status %d", w.Code)
+	}
+	unsigned.SkipVerification = true
+	if w := postWebhook(unsigned, "", webhookURL, inboundBatch); w.Code != http.StatusOK || len(unmatched) == 0 {
+		t.Errorf("got status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// InboundRouter matches recipients against inbound routes locally, the way
+// Mandrill routes the mail of its inbound domains.
+type InboundRouter struct {
+	domains map[string][]Route
+}
+
+// NewInboundRouter returns a router without routes.
+func NewInboundRouter() *InboundRouter {
+	return &InboundRouter{domains: make(map[string][]Route)}
+}
+
+// InboundRouter returns a router with the routes of every inbound domain of the account.
+func (a *MandrillAPI) InboundRouter() (*InboundRouter, error) {
+	domains, err := a.InboundDomainList()
+	if err != nil {
+		return nil, err
+	}
+	router := NewInboundRouter()
+	for _, domain := range domains {
+		routes, err := a.RouteList(domain.Domain)
+		if err != nil {
+			return nil, err
+		}
+		router.AddRoutes(domain.Domain, routes...)
+	}
+	return router, nil
+}
+
+// AddRoutes adds routes to an inbound domain, after the ones it already has.
+func (r *InboundRouter) AddRoutes(domain string, routes ...Route) {
+	domain = strings.ToLower(domain)
+	r.domains[domain] = append(r.domains[domain], routes...)
+}
+
+// Match returns the route receiving the mail of an address: the first route,
+// in the order they were added, of the address domain whose pattern matches
+// its mailbox.
+func (r *InboundRouter) Match(email string) (Route, bool) {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return Route{}, false
+	}
+	for _, route := range r.domains[strings.ToLower(email[at+1:])] {
+		if MatchRoutePattern(route.Pattern, email) {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// Resolve returns the recipients a route matches, with the webhook url
+// receiving their mail, as SendRawMIME reports them.
+func (r *InboundRouter) Resolve(to ...string) []InboundRecipient {
+	var recipients []InboundRecipient
+	for _, email := range to {
+		if route, found := r.Match(email); found {
+			recipients = append(recipients, InboundRecipient{Email: email, Pattern: route.Pattern, Url: route.Url})
+		}
+	}
+	return recipients
+}
+
+// InboundSimulator delivers raw MIME messages to local handlers, such as an
+// InboundHandler, the way the inbound send-raw call delivers them to route
+// webhooks. It lets routing be tested without the live service.
+type InboundSimulator struct {
+	Router *InboundRouter
+	// Handlers are keyed by route webhook url
+	Handlers map[string]http.Handler
+	// AuthKeys, keyed by route webhook url, sign the posts
+	AuthKeys map[string]string
+}
+
+// NewInboundSimulator returns a simulator routing with router.
+func NewInboundSimulator(router *InboundRouter) *InboundSimulator {
+	return &InboundSimulator{Router: router, Handlers: make(map[string]http.Handler), AuthKeys: make(map[string]string)}
+}
+
+// Handle sets the handler receiving the posts to a route webhook url, signed with authKey.
+func (s *InboundSimulator) Handle(webhookURL string, authKey string, handler http.Handler) {
+	s.Handlers[webhookURL] = handler
+	s.AuthKeys[webhookURL] = authKey
+}
+
+// SendRawMIME parses a raw message and posts it, as an inbound event, to the
+// handler of the route of each recipient. It takes the arguments of
+// MandrillAPI.SendRawMIME, recipients default to the To, Cc and Bcc headers
+// and mail_from, helo and client_address are not used. It errors when a route
+// has no handler or a handler does not answer 2xx.
+func (s *InboundSimulator) SendRawMIME(raw_message string, to []string, mail_from string, helo string, client_address string) ([]InboundRecipient, error) {
+	if raw_message == "" {
+		return nil, errors.New("raw_message cannot be blank")
+	}
+	message, headerRecipients, err := ParseInboundMIME(raw_message)
+	if err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		to = headerRecipients
+	}
+	recipients := s.Router.Resolve(to...)
+	var urls []string
+	batches := make(map[string][]WebhookEvent)
+	for _, recipient := range recipients {
+		msg := message
+		msg.Email = recipient.Email
+		if _, found := batches[recipient.Url]; !found {
+			urls = append(urls, recipient.Url)
+		}
+		batches[recipient.Url] = append(batches[recipient.Url], WebhookEvent{Event: WebhookEventInbound, Ts: TS{time.Now()}, Inbound: &msg})
+	}
+	for _, webhookURL := range urls {
+		if err := s.post(webhookURL, batches[webhookURL]); err != nil {
+			return recipients, err
+		}
+	}
+	return recipients, nil
+}
+
+func (s *InboundSimulator) post(webhookURL string, events []WebhookEvent) error {
+	handler, found := s.Handlers[webhookURL]
+	if !found {
+		return fmt.Errorf("no handler for %s", webhookURL)
+	}
+	batch := make([]map[string]interface{}, len(events))
+	for i, event := range events {
+		batch[i] = map[string]interface{}{"event": event.Event, "ts": event.Ts.Unix(), "msg": event.Inbound}
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"mandrill_events": {string(data)}}
+	r, err := http.NewRequest("POST", webhookURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if authKey := s.AuthKeys[webhookURL]; authKey != "" {
+		r.Header.Set(MandrillSignatureHeader, WebhookSignature(authKey, webhookURL, form))
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code < 200 || w.Code > 299 {
+		return fmt.Errorf("%s answered %d: %s", webhookURL, w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	return nil
+}
+
+// ParseInboundMIME parses a raw MIME message into the InboundMessage Mandrill
+// would post for it, and returns the addresses of its To, Cc and Bcc headers.
+// Email, the delivery address, is left blank.
+func ParseInboundMIME(raw string) (InboundMessage, []string, error) {
+	parsed, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return InboundMessage{}, nil, err
+	}
+	var decoder mime.WordDecoder
+	message := InboundMessage{RawMsg: raw, Headers: make(map[string]interface{})}
+	for key, values := range parsed.Header {
+		if len(values) == 1 {
+			message.Headers[key] = values[0]
+		} else {
+			message.Headers[key] = values
+		}
+	}
+	if subject, err := decoder.DecodeHeader(parsed.Header.Get("Subject")); err == nil {
+		message.Subject = subject
+	} else {
+		message.Subject = parsed.Header.Get("Subject")
+	}
+	if from, err := mail.ParseAddress(parsed.Header.Get("From")); err == nil {
+		message.FromEmail, message.FromName = from.Address, from.Name
+	}
+	var recipients []string
+	for _, header := range []string{"To", "Cc", "Bcc"} {
+		addresses, err := parsed.Header.AddressList(header)
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			recipients = append(recipients, address.Address)
+			if header == "To" {
+				message.To = append(message.To, []string{address.Address, address.Name})
+			}
+		}
+	}
+	err = parseInboundPart(&message, parsed.Header, parsed.Body)
+	return message, recipients, err
+}
+
+func parseInboundPart(message *InboundMessage, header map[string][]string, body io.Reader) error {
+	get := func(key string) string {
+		if values := header[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	contentType := get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := parseInboundPart(message, part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+	switch strings.ToLower(get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(get("Content-Disposition"))
+	name := dispositionParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	contentID := strings.Trim(get("Content-Id"), "<>")
+	switch {
+	case contentID != "" && strings.HasPrefix(mediaType, "image/") && disposition != "attachment":
+		if message.Images == nil {
+			message.Images = make(map[string]InboundAttachment)
+		}
+		message.Images[contentID] = newInboundAttachment(contentID, mediaType, data)
+	case disposition == "attachment" || name != "":
+		if message.Attachments == nil {
+			message.Attachments = make(map[string]InboundAttachment)
+		}
+		if name == "" {
+			name = unnamedAttachment(message.Attachments, mediaType)
+		}
+		message.Attachments[name] = newInboundAttachment(name, mediaType, data)
+	case mediaType == "text/html" && message.Html == "":
+		message.Html = decodeCharset(params["charset"], data)
+	case mediaType == "text/plain" && message.Text == "":
+		message.Text = decodeCharset(params["charset"], data)
+	}
+	return nil
+}
+
+// the extensions given to attachments without a filename, for the types
+// the system mime table maps to several
+var attachmentExtensions = map[string]string{
+	"text/plain": ".txt",
+	"text/html":  ".html",
+	"image/jpeg": ".jpg",
+}
+
+// unnamedAttachment returns a name for an attachment without a filename,
+// attachment-N with the extension of its type, that is not yet in attachments.
+func unnamedAttachment(attachments map[string]InboundAttachment, mediaType string) string {
+	ext, found := attachmentExtensions[mediaType]
+	if !found {
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	for n := len(attachments) + 1; ; n++ {
+		name := fmt.Sprintf("attachment-%d%s", n, ext)
+		if _, taken := attachments[name]; !taken {
+			return name
+		}
+	}
+}
+
+// windows1252 maps the bytes 0x80 to 0x9f of windows-1252 to their runes, the
+// others are those of iso-8859-1. Bytes it leaves undefined map to U+FFFD.
+var windows1252 = [32]rune{
+	'€', '\ufffd', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\ufffd', 'Ž', '\ufffd',
+	'\ufffd', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\ufffd', 'ž', 'Ÿ',
+}
+
+// decodeCharset converts a text body in charset to a string. UTF-8, ASCII,
+// ISO-8859-1 and Windows-1252 are decoded, other charsets are kept as they are.
+func decodeCharset(charset string, data []byte) string {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "iso-8859-1", "latin1", "l1", "iso_8859-1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	case "windows-1252", "cp1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			if b >= 0x80 && b < 0xa0 {
+				runes[i] = windows1252[b-0x80]
+			} else {
+				runes[i] = rune(b)
+			}
+		}
+		return string(runes)
+	}
+	return string(data)
+}
+
+// newInboundAttachment encodes binary data in base64, as Mandrill does.
+func newInboundAttachment(name string, mediaType string, data []byte) InboundAttachment {
+	attachment := InboundAttachment{Name: name, Type: mediaType, Data: data}
+	if strings.HasPrefix(mediaType, "text/") {
+		attachment.Content = string(data)
+	} else {
+		attachment.Base64 = true
+		attachment.Content = base64.StdEncoding.EncodeToString(data)
+	}
+	return attachment
+}
@@ -0,0 +1,174 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testInboundRouter() *InboundRouter {
+	router := NewInboundRouter()
+	router.AddRoutes("Example.com",
+		Route{Id: "1", Pattern: "billing+*", Url: "https://app.example.com/inbound/billing"},
+		Route{Id: "2", Pattern: "*", Url: "https://app.example.com/inbound/catchall"},
+	)
+	router.AddRoutes("replies.example.com", Route{Id: "3", Pattern: "*-reply", Url: "https://app.example.com/inbound/replies"})
+	return router
+}
+
+func TestInboundRouter(t *testing.T) {
+	router := testInboundRouter()
+	got := router.Resolve("billing+7@example.com", "hello@EXAMPLE.com", "t-1-reply@replies.example.com", "t-1@replies.example.com", "x@other.org")
+	want := []InboundRecipient{
+		{Email: "billing+7@example.com", Pattern: "billing+*", Url: "https://app.example.com/inbound/billing"},
+		{Email: "hello@EXAMPLE.com", Pattern: "*", Url: "https://app.example.com/inbound/catchall"},
+		{Email: "t-1-reply@replies.example.com", Pattern: "*-reply", Url: "https://app.example.com/inbound/replies"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+const inboundMIME = "From: Ann <ann@example.org>\r\n" +
+	"To: billing+7@example.com, Help <help@example.com>\r\n" +
+	"Cc: t-1-reply@replies.example.com\r\n" +
+	"Subject: =?UTF-8?Q?Invoice_=E2=82=AC10?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Total =E2=82=AC10\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Total</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=invoice.pdf\r\n" +
+	"Content-Disposition: attachment; filename=invoice.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0x\r\nLjQ=\r\n" +
+	"--outer--\r\n"
+
+func TestParseInboundMIME(t *testing.T) {
+	message, recipients, err := ParseInboundMIME(inboundMIME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Subject != "Invoice €10" || message.FromEmail != "ann@example.org" || message.FromName != "Ann" {
+		t.Errorf("wrong headers %+v", message)
+	}
+	if message.Text != "Total €10" || message.Html != "<p>Total</p>" {
+		t.Errorf("wrong body %q %q", message.Text, message.Html)
+	}
+	if pdf := message.Attachments["invoice.pdf"]; string(pdf.Data) != "%PDF-1.4" || !pdf.Base64 || pdf.Type != "application/pdf" {
+		t.Errorf("wrong attachment %+v", pdf)
+	}
+	if want := []string{"billing+7@example.com", "help@example.com", "t-1-reply@replies.example.com"}; !reflect.DeepEqual(recipients, want) {
+		t.Errorf("wrong recipients %v", recipients)
+	}
+	if len(message.To) != 2 || message.To[1][1] != "Help" {
+		t.Errorf("wrong to %v", message.To)
+	}
+}
+
+func TestParseInboundMIMEUnnamedParts(t *testing.T) {
+	raw := "From: ann@example.org\r\n" +
+		"Subject: Receipts\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=ISO-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Caf=E9 cr=E8me\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html; charset=windows-1252\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"<p>=8010 =93paid=94</p>\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment\r\n" +
+		"\r\n" +
+		"first\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment\r\n" +
+		"\r\n" +
+		"second\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Disposition: attachment\r\n" +
+		"\r\n" +
+		"notes\r\n" +
+		"--b--\r\n"
+	message, _, err := ParseInboundMIME(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Text != "Café crème" || message.Html != "<p>€10 “paid”</p>" {
+		t.Errorf("wrong charset decoding %q %q", message.Text, message.Html)
+	}
+	want := map[string]string{"attachment-1.pdf": "first", "attachment-2.pdf": "second", "attachment-3.txt": "notes"}
+	got := make(map[string]string)
+	for name, attachment := range message.Attachments {
+		got[name] = string(attachment.Data)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong attachments %v", got)
+	}
+}
+
+func TestInboundSimulator(t *testing.T) {
+	simulator := NewInboundSimulator(testInboundRouter())
+	var received []string
+	handler := NewInboundHandler("key", "https://app.example.com/inbound/billing")
+	handler.Handle("billing+*", func(message InboundMessage) error {
+		received = append(received, message.Email+" "+string(message.Attachments["invoice.pdf"].Data))
+		return nil
+	})
+	simulator.Handle("https://app.example.com/inbound/billing", "key", handler)
+	catchall := NewInboundHandler("", "")
+	catchall.SkipVerification = true
+	catchall.Handle("*", func(message InboundMessage) error {
+		received = append(received, message.Email+" "+message.Subject)
+		return nil
+	})
+	simulator.Handle("https://app.example.com/inbound/catchall", "", catchall)
+
+	recipients, err := simulator.SendRawMIME(inboundMIME, nil, "", "", "")
+	if err == nil || !strings.Contains(err.Error(), "no handler for https://app.example.com/inbound/replies") {
+		t.Errorf("expected a missing handler error, got %v", err)
+	}
+	if len(recipients) != 3 {
+		t.Errorf("wrong recipients %+v", recipients)
+	}
+	want := []string{"billing+7@example.com %PDF-1.4", "help@example.com Invoice €10"}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("got %q", received)
+	}
+
+	simulator.AuthKeys["https://app.example.com/inbound/billing"] = "wrong"
+	if _, err := simulator.SendRawMIME(inboundMIME, []string{"billing+1@example.com"}, "", "", ""); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected a signature error, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"errors"
+)
+
+// see https://mandrillapp.com/api/docs/ips.JSON.html
+const ips_list_endpoint string = "/ips/list.json"                         //Lists your dedicated IPs.
+const ips_info_endpoint string = "/ips/info.json"                         //Retrieves information about a single dedicated ip.
+const ips_provision_endpoint string = "/ips/provision.json"               //Requests an additional dedicated IP for your account.
+const ips_start_warmup_endpoint string = "/ips/start-warmup.json"         //Begins the warmup process for a dedicated IP.
+const ips_cancel_warmup_endpoint string = "/ips/cancel-warmup.json"       //Cancels the warmup process for a dedicated IP.
+const ips_set_pool_endpoint string = "/ips/set-pool.json"                 //Moves a dedicated IP to a different pool.
+const ips_delete_endpoint string = "/ips/delete.json"                     //Deletes a dedicated IP. This is permanent and cannot be undone.
+const ips_list_pools_endpoint string = "/ips/list-pools.json"             //Lists your dedicated IP pools.
+const ips_pool_info_endpoint string = "/ips/pool-info.json"               //Describes a single dedicated IP pool.
+const ips_create_pool_endpoint string = "/ips/create-pool.json"           //Creates a pool and returns it. If a pool already exists with this name, no action will be performed.
+const ips_delete_pool_endpoint string = "/ips/delete-pool.json"           //Deletes a pool. A pool must be empty before you can delete it.
+const ips_check_custom_dns_endpoint string = "/ips/check-custom-dns.json" //Tests whether a domain name is valid for use as the custom reverse DNS for a dedicated IP.
+const ips_set_custom_dns_endpoint string = "/ips/set-custom-dns.json"     //Configures the custom DNS name for a dedicated IP.
+
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPList() ([]IP, error) {
+	var response []IP
+	var params map[string]interface{} = make(map[string]interface{})
+	err := parseMandrillJson(a, ips_list_endpoint, params, &response)
+	return response, err
+}
+
+// can error with one of the following: Unknown_IP, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPInfo(ip string) (IP, error) {
+	return getIP(a, ip, ips_info_endpoint)
+}
+
+// IPProvision requests an additional dedicated IP. If warmup is true the new IP
+// is warmed up before it is used for full volume. The pool may be left blank to
+// use the default pool.
+//
+// can error with one of the following: IP_ProvisionLimit, Unknown_Pool, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPProvision(warmup bool, pool string) (IPProvisionResponse, error) {
+	var response IPProvisionResponse
+	var params map[string]interface{} = make(map[string]interface{})
+	params["warmup"] = warmup
+	if pool != "" {
+		params["pool"] = pool
+	}
+	err := parseMandrillJson(a, ips_provision_endpoint, params, &response)
+	return response, err
+}
+
+// can error with one of the following: Unknown_IP, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPStartWarmup(ip string) (IP, error) {
+	return getIP(a, ip, ips_start_warmup_endpoint)
+}
+
+// can error with one of the following: Unknown_IP, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPCancelWarmup(ip string) (IP, error) {
+	return getIP(a, ip, ips_cancel_warmup_endpoint)
+}
+
+// IPSetPool moves a dedicated IP to a different pool. When createPool is true
+// the pool is created if it does not already exist.
+//
+// can error with one of the following: Unknown_IP, Unknown_Pool, Invalid_Key, Invalid_EmptyDefaultPool, ValidationError, GeneralError
+func (a *MandrillAPI) IPSetPool(ip string, pool string, createPool bool) (IP, error) {
+	var response IP
+	if ip == "" {
+		return response, errors.New("ip cannot be blank")
+	}
+	if pool == "" {
+		return response, errors.New("pool cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["ip"] = ip
+	params["pool"] = pool
+	params["create_pool"] = createPool
+	err := parseMandrillJson(a, ips_set_pool_endpoint, params, &response)
+	return response, err
+}
+
+// can error with one of the following: Unknown_IP, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPDelete(ip string) (IPDeleteResponse, error) {
+	var response IPDeleteResponse
+	if ip == "" {
+		return response, errors.New("ip cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["ip"] = ip
+	err := parseMandrillJson(a, ips_delete_endpoint, params, &response)
+	return response, err
+}
+
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPPoolList() ([]IPPool, error) {
+	var response []IPPool
+	var params map[string]interface{} = make(map[string]interface{})
+	err := parseMandrillJson(a, ips_list_pools_endpoint, params, &response)
+	return response, err
+}
+
+// can error with one of the following: Unknown_Pool, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPPoolInfo(pool string) (IPPool, error) {
+	return getIPPool(a, pool, ips_pool_info_endpoint)
+}
+
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPPoolCreate(pool string) (IPPool, error) {
+	return getIPPool(a, pool, ips_create_pool_endpoint)
+}
+
+// can error with one of the following: Unknown_Pool, Invalid_Key, Invalid_DeleteDefaultPool, Invalid_DeleteNonEmptyPool, ValidationError, GeneralError
+func (a *MandrillAPI) IPPoolDelete(pool string) (IPPoolDeleteResponse, error) {
+	var response IPPoolDeleteResponse
+	if pool == "" {
+		return response, errors.New("pool cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["pool"] = pool
+	err := parseMandrillJson(a, ips_delete_pool_endpoint, params, &response)
+	return response, err
+}
+
+// can error with one of the following: Unknown_IP, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPCheckCustomDns(ip string, domain string) (IPCustomDnsCheck, error) {
+	var response IPCustomDnsCheck
+	if ip == "" {
+		return response, errors.New("ip cannot be blank")
+	}
+	if domain == "" {
+		return response, errors.New("domain cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["ip"] = ip
+	params["domain"] = domain
+	err := parseMandrillJson(a, ips_check_custom_dns_endpoint, params, &response)
+	return response, err
+}
+
+// can error with one of the following: Unknown_IP, Invalid_CustomDNS, Invalid_CustomDNSPending, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) IPSetCustomDns(ip string, domain string) (IP, error) {
+	var response IP
+	if ip == "" {
+		return response, errors.New("ip cannot be blank")
+	}
+	if domain == "" {
+		return response, errors.New("domain cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["ip"] = ip
+	params["domain"] = domain
+	err := parseMandrillJson(a, ips_set_custom_dns_endpoint, params, &response)
+	return response, err
+}
+
+func getIP(a *MandrillAPI, ip string, endpoint string) (IP, error) {
+	var response IP
+	if ip == "" {
+		return response, errors.New("ip cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["ip"] = ip
+	err := parseMandrillJson(a, endpoint, params, &response)
+	return response, err
+}
+
+func getIPPool(a *MandrillAPI, pool string, endpoint string) (IPPool, error) {
+	var response IPPool
+	if pool == "" {
+		return response, errors.New("pool cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["pool"] = pool
+	err := parseMandrillJson(a, endpoint, params, &response)
+	return response, err
+}
+
+type IP struct {
+	IP        string      `json:"ip"`
+	CreatedAt APITime     `json:"created_at"`
+	Pool      string      `json:"pool"`
+	Domain    string      `json:"domain"`
+	CustomDns IPCustomDns `json:"custom_dns"`
+	Warmup    IPWarmup    `json:"warmup"`
+}
+
+type IPCustomDns struct {
+	Enabled bool   `json:"enabled"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error"`
+}
+
+type IPWarmup struct {
+	WarmingUp bool    `json:"warming_up"`
+	StartAt   APITime `json:"start_at"`
+	EndAt     APITime `json:"end_at"`
+}
+
+type IPPool struct {
+	Name      string  `json:"name"`
+	CreatedAt APITime `json:"created_at"`
+	IPs       []IP    `json:"ips"`
+}
+
+type IPProvisionResponse struct {
+	RequestedAt APITime `json:"requested_at"`
+}
+
+type IPDeleteResponse struct {
+	IP      string `json:"ip"`
+	Deleted bool   `json:"deleted"`
+}
+
+type IPPoolDeleteResponse struct {
+	Pool    string `json:"pool"`
+	Deleted bool   `json:"deleted"`
+}
+
+type IPCustomDnsCheck struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error"`
+}
@@ -0,0 +1,148 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mandrillCall is a request made to a fakeMandrillServer, its params without the key.
+type mandrillCall struct {
+	Path   string
+	Params map[string]interface{}
+}
+
+// fakeMandrillServer answers each endpoint path with its JSON in answers and
+// records the calls. Paths without an answer get an Unknown_Method error.
+func fakeMandrillServer(answers map[string]string) (*MandrillAPI, *[]mandrillCall, func()) {
+	calls := &[]mandrillCall{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := make(map[string]interface{})
+		json.NewDecoder(r.Body).Decode(&params)
+		delete(params, "key")
+		*calls = append(*calls, mandrillCall{Path: r.URL.Path, Params: params})
+		answer, found := answers[r.URL.Path]
+		if !found {
+			w.WriteHeader(http.StatusInternalServerError)
+			answer = `{"status":"error","code":-1,"name":"Unknown_Method","message":"no answer for ` + r.URL.Path + `"}`
+		}
+		w.Write([]byte(answer))
+	}))
+	return &MandrillAPI{endpoint: srv.URL}, calls, srv.Close
+}
+
+func TestIPs(t *testing.T) {
+	const ip = `{"ip":"127.0.0.1","created_at":"2013-01-01 15:50:40","pool":"Main Pool","domain":"mail7.example.mandrillapp.com",
+		"custom_dns":{"enabled":true,"valid":false,"error":"DNS is not set"},
+		"warmup":{"warming_up":true,"start_at":"2013-03-01 12:00:01","end_at":"2013-03-31 12:00:01"}}`
+	api, calls, stop := fakeMandrillServer(map[string]string{
+		ips_list_endpoint:             "[" + ip + "]",
+		ips_info_endpoint:             ip,
+		ips_provision_endpoint:        `{"requested_at":"2013-01-01 01:52:21"}`,
+		ips_start_warmup_endpoint:     ip,
+		ips_cancel_warmup_endpoint:    ip,
+		ips_set_pool_endpoint:         ip,
+		ips_delete_endpoint:           `{"ip":"127.0.0.1","deleted":true}`,
+		ips_list_pools_endpoint:       `[{"name":"Main Pool","created_at":"2013-01-01 01:52:21","ips":[` + ip + `]}]`,
+		ips_pool_info_endpoint:        `{"name":"Main Pool","created_at":"2013-01-01 01:52:21","ips":[]}`,
+		ips_create_pool_endpoint:      `{"name":"Bulk","created_at":"2013-01-01 01:52:21","ips":[]}`,
+		ips_delete_pool_endpoint:      `{"pool":"Bulk","deleted":true}`,
+		ips_check_custom_dns_endpoint: `{"valid":false,"error":"PTR record mismatch"}`,
+		ips_set_custom_dns_endpoint:   ip,
+	})
+	defer stop()
+
+	ips, err := api.IPList()
+	if err != nil || len(ips) != 1 {
+		t.Fatalf("IPList %+v %v", ips, err)
+	}
+	got := ips[0]
+	if got.IP != "127.0.0.1" || got.Pool != "Main Pool" || !got.CustomDns.Enabled || got.CustomDns.Error != "DNS is not set" {
+		t.Errorf("wrong ip %+v", got)
+	}
+	if !got.Warmup.WarmingUp || !got.Warmup.EndAt.Equal(time.Date(2013, 3, 31, 12, 0, 1, 0, time.UTC)) ||
+		!got.CreatedAt.Equal(time.Date(2013, 1, 1, 15, 50, 40, 0, time.UTC)) {
+		t.Errorf("wrong times %+v", got)
+	}
+	provisioned, err := api.IPProvision(true, "")
+	if err != nil || !provisioned.RequestedAt.Equal(time.Date(2013, 1, 1, 1, 52, 21, 0, time.UTC)) {
+		t.Errorf("IPProvision %+v %v", provisioned, err)
+	}
+	api.IPProvision(false, "Bulk")
+	api.IPInfo("127.0.0.1")
+	api.IPStartWarmup("127.0.0.1")
+	api.IPCancelWarmup("127.0.0.1")
+	api.IPSetPool("127.0.0.1", "Bulk", true)
+	if deleted, err := api.IPDelete("127.0.0.1"); err != nil || !deleted.Deleted {
+		t.Errorf("IPDelete %+v %v", deleted, err)
+	}
+	if pools, err := api.IPPoolList(); err != nil || len(pools) != 1 || len(pools[0].IPs) != 1 {
+		t.Errorf("IPPoolList %+v %v", pools, err)
+	}
+	api.IPPoolInfo("Main Pool")
+	if pool, err := api.IPPoolCreate("Bulk"); err != nil || pool.Name != "Bulk" {
+		t.Errorf("IPPoolCreate %+v %v", pool, err)
+	}
+	if deleted, err := api.IPPoolDelete("Bulk"); err != nil || !deleted.Deleted || deleted.Pool != "Bulk" {
+		t.Errorf("IPPoolDelete %+v %v", deleted, err)
+	}
+	if check, err := api.IPCheckCustomDns("127.0.0.1", "mail.example.com"); err != nil || check.Valid || check.Error != "PTR record mismatch" {
+		t.Errorf("IPCheckCustomDns %+v %v", check, err)
+	}
+	api.IPSetCustomDns("127.0.0.1", "mail.example.com")
+
+	expected := []mandrillCall{
+		{ips_list_endpoint, map[string]interface{}{}},
+		{ips_provision_endpoint, map[string]interface{}{"warmup": true}},
+		{ips_provision_endpoint, map[string]interface{}{"warmup": false, "pool": "Bulk"}},
+		{ips_info_endpoint, map[string]interface{}{"ip": "127.0.0.1"}},
+		{ips_start_warmup_endpoint, map[string]interface{}{"ip": "127.0.0.1"}},
+		{ips_cancel_warmup_endpoint, map[string]interface{}{"ip": "127.0.0.1"}},
+		{ips_set_pool_endpoint, map[string]interface{}{"ip": "127.0.0.1", "pool": "Bulk", "create_pool": true}},
+		{ips_delete_endpoint, map[string]interface{}{"ip": "127.0.0.1"}},
+		{ips_list_pools_endpoint, map[string]interface{}{}},
+		{ips_pool_info_endpoint, map[string]interface{}{"pool": "Main Pool"}},
+		{ips_create_pool_endpoint, map[string]interface{}{"pool": "Bulk"}},
+		{ips_delete_pool_endpoint, map[string]interface{}{"pool": "Bulk"}},
+		{ips_check_custom_dns_endpoint, map[string]interface{}{"ip": "127.0.0.1", "domain": "mail.example.com"}},
+		{ips_set_custom_dns_endpoint, map[string]interface{}{"ip": "127.0.0.1", "domain": "mail.example.com"}},
+	}
+	if !reflect.DeepEqual(*calls, expected) {
+		t.Errorf("wrong calls\n got %+v\nwant %+v", *calls, expected)
+	}
+}
+
+func TestIPErrors(t *testing.T) {
+	api, calls, stop := fakeMandrillServer(map[string]string{})
+	defer stop()
+	if _, err := api.IPInfo(""); err == nil {
+		t.Error("expected a blank ip error")
+	}
+	if _, err := api.IPSetPool("127.0.0.1", "", false); err == nil {
+		t.Error("expected a blank pool error")
+	}
+	if _, err := api.IPCheckCustomDns("127.0.0.1", ""); err == nil {
+		t.Error("expected a blank domain error")
+	}
+	if len(*calls) != 0 {
+		t.Errorf("blank arguments were sent %+v", *calls)
+	}
+	_, err := api.IPDelete("127.0.0.2")
+	if apiErr, ok := err.(MandrillError); !ok || apiErr.Name != "Unknown_Method" {
+		t.Errorf("expected the Mandrill error, got %v", err)
+	}
+}
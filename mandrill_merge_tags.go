@@ -0,0 +1,360 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// the merge languages a Message can use, see Message.MergeLanguage
+const (
+	MergeLanguageMailchimp  = "mailchimp"
+	MergeLanguageHandlebars = "handlebars"
+)
+
+// BuiltinMergeTags are filled in by Mandrill or MailChimp themselves, so they
+// never need a merge var.
+var BuiltinMergeTags = map[string]bool{
+	"MC:SUBJECT":       true,
+	"MC:TOEMAIL":       true,
+	"MC:DATE":          true,
+	"MC_PREVIEW_TEXT":  true,
+	"CURRENT_YEAR":     true,
+	"DATE":             true,
+	"EMAIL":            true,
+	"UNSUB":            true,
+	"UPDATE_PROFILE":   true,
+	"FORWARD":          true,
+	"ARCHIVE":          true,
+	"REWARDS":          true,
+	"LIST:NAME":        true,
+	"LIST:COMPANY":     true,
+	"LIST:ADDRESS":     true,
+	"LIST:DESCRIPTION": true,
+}
+
+var (
+	mailchimpMergeTag  = regexp.MustCompile(`\*\|([^|*]*)\|\*`)
+	handlebarsMergeTag = regexp.MustCompile(`\{\{\{?\s*([^{}]*?)\s*\}?\}\}`)
+	mcEditRegion       = regexp.MustCompile(`mc:edit\s*=\s*["']([^"']*)["']`)
+)
+
+// the mailchimp merge tag modifiers that wrap a variable, *|UPPER:FNAME|* for example
+var mailchimpModifiers = []string{"HTML:", "UPPER:", "LOWER:", "TITLE:", "URL:"}
+
+// MergeTag is one use of a merge variable in a template.
+type MergeTag struct {
+	// Name is the upper cased variable name, Mandrill merge vars are case insensitive
+	Name string
+	// Conditional tags only test the variable, *|IF:NAME|* or {{#if name}}, or
+	// sit inside such a block, so the template still renders when it is missing
+	Conditional bool
+	Line        int
+}
+
+// TemplateTags lists the merge tags and mc:edit regions found in template code.
+type TemplateTags struct {
+	MergeTags   []MergeTag
+	EditRegions []string
+}
+
+// Names returns each merge variable used, once, sorted.
+func (t TemplateTags) Names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, tag := range t.MergeTags {
+		if !seen[tag.Name] {
+			seen[tag.Name] = true
+			names = append(names, tag.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// required returns the variables used outside of conditionals.
+func (t TemplateTags) required() map[string]bool {
+	required := make(map[string]bool)
+	for _, tag := range t.MergeTags {
+		if !tag.Conditional {
+			required[tag.Name] = true
+		}
+	}
+	return required
+}
+
+// ExtractTemplateTags finds every merge tag and mc:edit region in template
+// code, such as the Code of a Template returned by TemplateInfo or a local
+// file. mergeLanguage is the Message.MergeLanguage the template is sent with,
+// blank meaning mailchimp. Builtin tags such as *|UNSUB|* are left out.
+func ExtractTemplateTags(code string, mergeLanguage string) TemplateTags {
+	var tags TemplateTags
+	if strings.EqualFold(mergeLanguage, MergeLanguageHandlebars) {
+		tags.MergeTags = extractHandlebarsTags(code)
+	} else {
+		tags.MergeTags = extractMailchimpTags(code)
+	}
+	seen := make(map[string]bool)
+	for _, m := range mcEditRegion.FindAllStringSubmatch(code, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			tags.EditRegions = append(tags.EditRegions, m[1])
+		}
+	}
+	return tags
+}
+
+func extractMailchimpTags(code string) []MergeTag {
+	var tags []MergeTag
+	// the number of *|IF:|* blocks open, the tags inside only render under a condition
+	depth := 0
+	for _, loc := range mailchimpMergeTag.FindAllStringSubmatchIndex(code, -1) {
+		tag := strings.ToUpper(strings.TrimSpace(code[loc[2]:loc[3]]))
+		conditional := depth > 0
+		switch {
+		case tag == "END:IF":
+			if depth > 0 {
+				depth--
+			}
+			continue
+		case tag == "" || tag == "ELSE:" || BuiltinMergeTags[tag]:
+			continue
+		case strings.HasPrefix(tag, "IF:"), strings.HasPrefix(tag, "ELSEIF:"), strings.HasPrefix(tag, "IFNOT:"):
+			conditional = true
+			if !strings.HasPrefix(tag, "ELSEIF:") {
+				depth++
+			}
+			tag = tag[strings.Index(tag, ":")+1:]
+			// *|IF:NAME=value|* compares the variable to a value
+			if i := strings.IndexAny(tag, "=!<>"); i >= 0 {
+				tag = strings.TrimSpace(tag[:i])
+			}
+		case strings.HasPrefix(tag, "DATE:"), strings.HasPrefix(tag, "UNSUB:"), strings.HasPrefix(tag, "LIST:"):
+			continue
+		default:
+			for _, modifier := range mailchimpModifiers {
+				tag = strings.TrimPrefix(tag, modifier)
+			}
+		}
+		if tag == "" || BuiltinMergeTags[tag] {
+			continue
+		}
+		tags = append(tags, MergeTag{Name: tag, Conditional: conditional, Line: lineAt(code, loc[0])})
+	}
+	return tags
+}
+
+func extractHandlebarsTags(code string) []MergeTag {
+	var tags []MergeTag
+	// variables inside {{#each}} and {{#with}} refer to the current item, not to merge vars
+	scoped := 0
+	// the number of {{#if}} and {{#unless}} blocks open
+	depth := 0
+	for _, loc := range handlebarsMergeTag.FindAllStringSubmatchIndex(code, -1) {
+		expr := strings.TrimSpace(code[loc[2]:loc[3]])
+		if strings.HasPrefix(expr, "!") {
+			continue
+		}
+		if strings.HasPrefix(expr, "/") {
+			block := strings.TrimSpace(expr[1:])
+			if (block == "each" || block == "with") && scoped > 0 {
+				scoped--
+			}
+			if (block == "if" || block == "unless") && depth > 0 {
+				depth--
+			}
+			continue
+		}
+		block := strings.HasPrefix(expr, "#")
+		fields := strings.Fields(strings.TrimPrefix(expr, "#"))
+		// {{else}} and {{else if x}} continue the block already open
+		if len(fields) > 0 && fields[0] == "else" {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		args := fields
+		helper := ""
+		// a helper has arguments, a lone {{title}} is a merge var
+		if len(fields) > 1 {
+			helper, args = fields[0], fields[1:]
+		}
+		if scoped == 0 {
+			for _, arg := range args {
+				name := handlebarsVariable(arg)
+				if name == "" || BuiltinMergeTags[name] {
+					continue
+				}
+				conditional := helper == "if" || helper == "unless" || depth > 0
+				tags = append(tags, MergeTag{Name: name, Conditional: conditional, Line: lineAt(code, loc[0])})
+			}
+		}
+		if block && (helper == "each" || helper == "with") {
+			scoped++
+		}
+		if block && (helper == "if" || helper == "unless") {
+			depth++
+		}
+	}
+	return tags
+}
+
+// handlebarsVariable returns the merge var an argument refers to, or "" for
+// literals and helper keywords.
+func handlebarsVariable(arg string) string {
+	if arg == "" || arg == "else" || strings.ContainsAny(arg[:1], `"'0123456789-@(`) || arg == "this" || arg == "true" || arg == "false" {
+		return ""
+	}
+	if strings.Contains(arg, "=") {
+		return ""
+	}
+	arg = strings.TrimPrefix(arg, "this.")
+	if i := strings.IndexAny(arg, ".["); i > 0 {
+		arg = arg[:i]
+	}
+	return strings.ToUpper(arg)
+}
+
+func lineAt(code string, offset int) int {
+	return strings.Count(code[:offset], "\n") + 1
+}
+
+// RecipientMergeReport is the merge var coverage for one recipient of a Message.
+type RecipientMergeReport struct {
+	Recipient string
+	// Missing variables are used by the template but given neither globally
+	// nor for this recipient, they render blank
+	Missing []string
+	// Unused variables were given for this recipient but the template never uses them
+	Unused []string
+}
+
+// MergeCoverage is the result of CheckMergeVars.
+type MergeCoverage struct {
+	Recipients []RecipientMergeReport
+	// UnusedGlobal lists the GlobalMergeVars the template never uses
+	UnusedGlobal []string
+	// UnknownRecipients have MergeVars but are not among the message recipients
+	UnknownRecipients []string
+	// UnusedContent lists the template content given for mc:edit regions the template does not have
+	UnusedContent []string
+}
+
+// Complete reports whether every recipient gets every merge var it needs.
+func (c MergeCoverage) Complete() bool {
+	for _, r := range c.Recipients {
+		if len(r.Missing) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns an error describing the missing merge vars, or nil when coverage is complete.
+func (c MergeCoverage) Err() error {
+	var problems []string
+	for _, r := range c.Recipients {
+		if len(r.Missing) > 0 {
+			problems = append(problems, fmt.Sprintf("%s is missing %s", r.Recipient, strings.Join(r.Missing, ", ")))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("merge vars missing: %s", strings.Join(problems, "; "))
+}
+
+// CheckMergeVars compares the merge tags of a template with the merge vars of a
+// message before it is sent with MessageSendTemplate. Variables only used in
+// conditionals are never reported missing.
+func CheckMergeVars(tags TemplateTags, templateContent []Var, message Message) MergeCoverage {
+	var coverage MergeCoverage
+	used := make(map[string]bool)
+	for _, name := range tags.Names() {
+		used[name] = true
+	}
+	required := tags.required()
+
+	global := make(map[string]bool)
+	for _, v := range message.GlobalMergeVars {
+		name := strings.ToUpper(v.Name)
+		global[name] = true
+		if !used[name] && !hasString(coverage.UnusedGlobal, name) {
+			coverage.UnusedGlobal = append(coverage.UnusedGlobal, name)
+		}
+	}
+
+	perRecipient := make(map[string][]Var)
+	for _, mv := range message.MergeVars {
+		rcpt := strings.ToLower(mv.Recipient)
+		perRecipient[rcpt] = append(perRecipient[rcpt], mv.Vars...)
+	}
+	recipients := make(map[string]bool)
+	for _, to := range message.To {
+		rcpt := strings.ToLower(to.Email)
+		if recipients[rcpt] {
+			continue
+		}
+		recipients[rcpt] = true
+		report := RecipientMergeReport{Recipient: to.Email}
+		given := make(map[string]bool)
+		for _, v := range perRecipient[rcpt] {
+			name := strings.ToUpper(v.Name)
+			given[name] = true
+			if !used[name] && !hasString(report.Unused, name) {
+				report.Unused = append(report.Unused, name)
+			}
+		}
+		for _, name := range tags.Names() {
+			if required[name] && !given[name] && !global[name] {
+				report.Missing = append(report.Missing, name)
+			}
+		}
+		coverage.Recipients = append(coverage.Recipients, report)
+	}
+	for _, mv := range message.MergeVars {
+		if !recipients[strings.ToLower(mv.Recipient)] && !hasString(coverage.UnknownRecipients, mv.Recipient) {
+			coverage.UnknownRecipients = append(coverage.UnknownRecipients, mv.Recipient)
+		}
+	}
+	for _, content := range templateContent {
+		if !hasString(tags.EditRegions, content.Name) {
+			coverage.UnusedContent = append(coverage.UnusedContent, content.Name)
+		}
+	}
+	return coverage
+}
+
+// CheckTemplateMergeVars fetches a template with TemplateInfo and checks its
+// published code against the merge vars of a message, as CheckMergeVars does.
+func (a *MandrillAPI) CheckTemplateMergeVars(templateName string, templateContent []Var, message Message) (MergeCoverage, error) {
+	template, err := a.TemplateInfo(templateName)
+	if err != nil {
+		return MergeCoverage{}, err
+	}
+	code := template.PublishCode
+	if code == "" {
+		code = template.Code
+	}
+	tags := ExtractTemplateTags(code, message.MergeLanguage)
+	// the subject is merged too, the template one is used when the message has none
+	subject := message.Subject
+	if subject == "" {
+		subject = template.PublishSubject
+	}
+	tags.MergeTags = append(tags.MergeTags, ExtractTemplateTags(subject, message.MergeLanguage).MergeTags...)
+	return CheckMergeVars(tags, templateContent, message), nil
+}
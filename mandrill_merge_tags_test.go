@@ -0,0 +1,86 @@
+package gochimp
+
+import (
+	"reflect"
+	"testing"
+)
+
+const mergeTagsTemplate = `<h1>Hi *|FNAME|*</h1>
+*|IF:COMPANY|*<p>*|UPPER:COMPANY|*</p>*|ELSE:|*<p>friend</p>*|END:IF|*
+<div mc:edit="main"></div><a href="*|UNSUB|*">unsubscribe</a> *|IF:PLAN=pro|*pro*|END:IF|*
+*|MC:SUBJECT|* *|lname|*`
+
+func TestExtractTemplateTags(t *testing.T) {
+	tags := ExtractTemplateTags(mergeTagsTemplate, "")
+	expected := []MergeTag{
+		{Name: "FNAME", Line: 1},
+		{Name: "COMPANY", Conditional: true, Line: 2},
+		{Name: "COMPANY", Conditional: true, Line: 2},
+		{Name: "PLAN", Conditional: true, Line: 3},
+		{Name: "LNAME", Line: 4},
+	}
+	if !reflect.DeepEqual(tags.MergeTags, expected) {
+		t.Errorf("wrong merge tags\nexpected %+v\ngot      %+v", expected, tags.MergeTags)
+	}
+	if !reflect.DeepEqual(tags.EditRegions, []string{"main"}) {
+		t.Errorf("wrong edit regions %v", tags.EditRegions)
+	}
+}
+
+func TestExtractHandlebarsTags(t *testing.T) {
+	code := `{{#if vip}}<b>{{upper name}}</b>{{else}}{{name}}{{/if}} {{title}} {{url}}
+{{#each items}}{{title}} {{price}}{{/each}} {{{footer_html}}} {{! a comment}}`
+	tags := ExtractTemplateTags(code, MergeLanguageHandlebars)
+	if names := tags.Names(); !reflect.DeepEqual(names, []string{"FOOTER_HTML", "ITEMS", "NAME", "TITLE", "URL", "VIP"}) {
+		t.Errorf("wrong handlebars names %v", names)
+	}
+	for _, tag := range tags.MergeTags {
+		if conditional := tag.Name == "VIP" || tag.Name == "NAME"; tag.Conditional != conditional {
+			t.Errorf("only the if argument and its block should be conditional: %+v", tag)
+		}
+	}
+}
+
+func TestExtractHandlebarsElseIf(t *testing.T) {
+	code := "{{#if vip}}VIP{{else if member}}{{name}}{{else unless guest}}-{{else}}{{fallback}}{{/if}}\n{{footer}}"
+	tags := ExtractTemplateTags(code, MergeLanguageHandlebars)
+	want := []MergeTag{
+		{Name: "VIP", Conditional: true, Line: 1},
+		{Name: "MEMBER", Conditional: true, Line: 1},
+		{Name: "NAME", Conditional: true, Line: 1},
+		{Name: "GUEST", Conditional: true, Line: 1},
+		{Name: "FALLBACK", Conditional: true, Line: 1},
+		{Name: "FOOTER", Line: 2},
+	}
+	if !reflect.DeepEqual(tags.MergeTags, want) {
+		t.Errorf("got %+v", tags.MergeTags)
+	}
+}
+
+func TestCheckMergeVars(t *testing.T) {
+	message := Message{
+		To:              []Recipient{{Email: "a@example.com"}, {Email: "b@example.com"}},
+		GlobalMergeVars: []Var{{Name: "lname", Content: "Smith"}, {Name: "FOOTER", Content: "x"}},
+		MergeVars: []MergeVars{
+			{Recipient: "a@example.com", Vars: []Var{{Name: "FNAME", Content: "Ann"}, {Name: "COMPANY", Content: "Acme"}}},
+			{Recipient: "b@example.com", Vars: []Var{{Name: "NICKNAME", Content: "Bob"}}},
+			{Recipient: "c@example.com", Vars: []Var{{Name: "FNAME", Content: "Cat"}}},
+		},
+	}
+	coverage := CheckMergeVars(ExtractTemplateTags(mergeTagsTemplate, ""), []Var{{Name: "main"}, {Name: "sidebar"}}, message)
+	expected := MergeCoverage{
+		Recipients: []RecipientMergeReport{
+			{Recipient: "a@example.com"},
+			{Recipient: "b@example.com", Missing: []string{"FNAME"}, Unused: []string{"NICKNAME"}},
+		},
+		UnusedGlobal:      []string{"FOOTER"},
+		UnknownRecipients: []string{"c@example.com"},
+		UnusedContent:     []string{"sidebar"},
+	}
+	if !reflect.DeepEqual(coverage, expected) {
+		t.Errorf("wrong coverage\nexpected %+v\ngot      %+v", expected, coverage)
+	}
+	if coverage.Complete() || coverage.Err() == nil {
+		t.Error("coverage should not be complete")
+	}
+}
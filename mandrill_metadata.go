@@ -0,0 +1,121 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"errors"
+)
+
+// see https://mandrillapp.com/api/docs/metadata.JSON.html
+const metadata_list_endpoint string = "/metadata/list.json"     //Get the list of custom metadata fields indexed for the account.
+const metadata_add_endpoint string = "/metadata/add.json"       //Add a new custom metadata field to be indexed for the account.
+const metadata_update_endpoint string = "/metadata/update.json" //Update an existing custom metadata field.
+const metadata_delete_endpoint string = "/metadata/delete.json" //Delete an existing custom metadata field. Deletion isn't instantaneous.
+
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) MetadataList() ([]MetadataField, error) {
+	var response []MetadataField
+	var params map[string]interface{} = make(map[string]interface{})
+	err := parseMandrillJson(a, metadata_list_endpoint, params, &response)
+	return response, err
+}
+
+// MetadataAdd registers a metadata field so that Mandrill indexes it for search.
+// viewTemplate is an optional Mustache template used to render the value in the
+// Mandrill UI and may be left blank.
+//
+// can error with one of the following: Metadata_FieldLimit, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) MetadataAdd(name string, viewTemplate string) (MetadataField, error) {
+	if name == "" {
+		return MetadataField{}, errors.New("name cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["name"] = name
+	if viewTemplate != "" {
+		params["view_template"] = viewTemplate
+	}
+	return getMetadataField(a, params, metadata_add_endpoint)
+}
+
+// can error with one of the following: Unknown_MetadataField, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) MetadataUpdate(name string, viewTemplate string) (MetadataField, error) {
+	if name == "" {
+		return MetadataField{}, errors.New("name cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["name"] = name
+	params["view_template"] = viewTemplate
+	return getMetadataField(a, params, metadata_update_endpoint)
+}
+
+// can error with one of the following: Unknown_MetadataField, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) MetadataDelete(name string) (MetadataField, error) {
+	if name == "" {
+		return MetadataField{}, errors.New("name cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["name"] = name
+	return getMetadataField(a, params, metadata_delete_endpoint)
+}
+
+// EnsureMetadataFields makes sure every one of the given metadata keys is
+// registered with Mandrill, adding the ones that are missing. Fields that are
+// already registered are left untouched, including their view templates. It is
+// meant to be called once at startup with every key passed to
+// Message.AddMetadata or RecipientMetaData, and returns the fields it added.
+//
+// can error with one of the following: Metadata_FieldLimit, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) EnsureMetadataFields(names ...string) ([]MetadataField, error) {
+	var added []MetadataField
+	existing, err := a.MetadataList()
+	if err != nil {
+		return added, err
+	}
+	registered := make(map[string]bool, len(existing))
+	for _, field := range existing {
+		// fields pending deletion have to be re-added to be indexed again
+		if field.State != MetadataStateDelete {
+			registered[field.Name] = true
+		}
+	}
+	for _, name := range names {
+		if name == "" || registered[name] {
+			continue
+		}
+		field, err := a.MetadataAdd(name, "")
+		if err != nil {
+			return added, err
+		}
+		registered[name] = true
+		added = append(added, field)
+	}
+	return added, nil
+}
+
+func getMetadataField(a *MandrillAPI, params map[string]interface{}, endpoint string) (MetadataField, error) {
+	var response MetadataField
+	err := parseMandrillJson(a, endpoint, params, &response)
+	return response, err
+}
+
+// the states a metadata field can be in
+const (
+	MetadataStateActive = "active"
+	MetadataStateDelete = "delete"
+	MetadataStateIndex  = "index"
+)
+
+type MetadataField struct {
+	Name         string `json:"name"`
+	State        string `json:"state"`
+	ViewTemplate string `json:"view_template"`
+}
@@ -0,0 +1,91 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	api, calls, stop := fakeMandrillServer(map[string]string{
+		metadata_list_endpoint:   `[{"name":"website","state":"active","view_template":"<a href=\"{{value}}\">{{value}}</a>"}]`,
+		metadata_add_endpoint:    `{"name":"group_id","state":"index","view_template":""}`,
+		metadata_update_endpoint: `{"name":"website","state":"active","view_template":"{{value}}"}`,
+		metadata_delete_endpoint: `{"name":"website","state":"delete","view_template":""}`,
+	})
+	defer stop()
+	fields, err := api.MetadataList()
+	if err != nil || len(fields) != 1 || fields[0].State != MetadataStateActive || fields[0].ViewTemplate == "" {
+		t.Errorf("MetadataList %+v %v", fields, err)
+	}
+	if field, err := api.MetadataAdd("group_id", ""); err != nil || field.State != MetadataStateIndex {
+		t.Errorf("MetadataAdd %+v %v", field, err)
+	}
+	api.MetadataAdd("website", "{{value}}")
+	api.MetadataUpdate("website", "")
+	if field, err := api.MetadataDelete("website"); err != nil || field.State != MetadataStateDelete {
+		t.Errorf("MetadataDelete %+v %v", field, err)
+	}
+	expected := []mandrillCall{
+		{metadata_list_endpoint, map[string]interface{}{}},
+		{metadata_add_endpoint, map[string]interface{}{"name": "group_id"}},
+		{metadata_add_endpoint, map[string]interface{}{"name": "website", "view_template": "{{value}}"}},
+		// a blank template clears the one set
+		{metadata_update_endpoint, map[string]interface{}{"name": "website", "view_template": ""}},
+		{metadata_delete_endpoint, map[string]interface{}{"name": "website"}},
+	}
+	if !reflect.DeepEqual(*calls, expected) {
+		t.Errorf("wrong calls\n got %+v\nwant %+v", *calls, expected)
+	}
+	if _, err := api.MetadataAdd("", ""); err == nil {
+		t.Error("expected a blank name error")
+	}
+}
+
+func TestEnsureMetadataFields(t *testing.T) {
+	api, calls, stop := fakeMandrillServer(map[string]string{
+		metadata_list_endpoint: `[{"name":"website","state":"active"},{"name":"user_id","state":"index"},
+			{"name":"group_id","state":"delete"}]`,
+		metadata_add_endpoint: `{"name":"added","state":"index"}`,
+	})
+	defer stop()
+	added, err := api.EnsureMetadataFields("website", "user_id", "group_id", "", "plan", "plan")
+	if err != nil || len(added) != 2 {
+		t.Fatalf("EnsureMetadataFields %+v %v", added, err)
+	}
+	// fields being indexed are registered, fields pending deletion are not
+	expected := []mandrillCall{
+		{metadata_list_endpoint, map[string]interface{}{}},
+		{metadata_add_endpoint, map[string]interface{}{"name": "group_id"}},
+		{metadata_add_endpoint, map[string]interface{}{"name": "plan"}},
+	}
+	if !reflect.DeepEqual(*calls, expected) {
+		t.Errorf("wrong calls\n got %+v\nwant %+v", *calls, expected)
+	}
+
+	*calls = nil
+	if added, err := api.EnsureMetadataFields("website"); err != nil || len(added) != 0 || len(*calls) != 1 {
+		t.Errorf("registered fields were added %+v %v %+v", added, err, *calls)
+	}
+}
+
+func TestEnsureMetadataFieldsError(t *testing.T) {
+	api, _, stop := fakeMandrillServer(map[string]string{
+		metadata_list_endpoint: `[]`,
+	})
+	defer stop()
+	added, err := api.EnsureMetadataFields("website")
+	if _, ok := err.(MandrillError); !ok || len(added) != 0 {
+		t.Errorf("expected the add error, got %+v %v", added, err)
+	}
+}
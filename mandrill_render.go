@@ -0,0 +1,179 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// the name of the template holding the subject, {{define "subject"}}...{{end}}
+const subjectTemplateName = "subject"
+
+// MessageRenderer renders Go templates into the Html, Text and Subject of a
+// Message. A message named "welcome" is made of welcome.html, executed with
+// html/template, and an optional welcome.txt, executed with text/template.
+// Either file may define a "subject" template, the text one is preferred.
+//
+// Parsed templates are cached, so a renderer should be shared.
+type MessageRenderer struct {
+	FS fs.FS
+	// Partials are glob patterns of files parsed along with every template,
+	// for layouts and shared blocks. html partials end in .html, text ones in .txt.
+	Partials []string
+	Funcs    map[string]interface{}
+	// CSSInliner, when set, is applied to the rendered html
+	CSSInliner func(html string) (string, error)
+	// TextConverter generates the text part of messages without a .txt file,
+	// when nil HtmlToText is used with the default options
+	TextConverter func(html string) (string, error)
+	// NoCache parses the templates on every render, for development
+	NoCache bool
+
+	mu    sync.Mutex
+	cache map[string]*renderTemplates
+}
+
+type renderTemplates struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// NewMessageRenderer returns a renderer reading its templates from fsys.
+func NewMessageRenderer(fsys fs.FS) *MessageRenderer {
+	return &MessageRenderer{FS: fsys}
+}
+
+// Render executes the named templates with data and fills the Html, Text and,
+// when a subject template is defined, the Subject of message.
+func (r *MessageRenderer) Render(message *Message, name string, data interface{}) error {
+	if name == "" {
+		return errors.New("name cannot be blank")
+	}
+	templates, err := r.templates(name)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := templates.html.ExecuteTemplate(&buf, path.Base(name)+".html", data); err != nil {
+		return err
+	}
+	body := buf.String()
+	if r.CSSInliner != nil {
+		if body, err = r.CSSInliner(body); err != nil {
+			return err
+		}
+	}
+	text := ""
+	if templates.text != nil {
+		buf.Reset()
+		if err := templates.text.ExecuteTemplate(&buf, path.Base(name)+".txt", data); err != nil {
+			return err
+		}
+		text = buf.String()
+	} else {
+		convert := r.TextConverter
+		if convert == nil {
+			convert = HtmlToTextOptions{}.Convert
+		}
+		if text, err = convert(body); err != nil {
+			return err
+		}
+	}
+	subject, found, err := renderSubject(templates, data)
+	if err != nil {
+		return err
+	}
+	message.Html = body
+	message.Text = text
+	if found {
+		message.Subject = subject
+	}
+	return nil
+}
+
+func renderSubject(templates *renderTemplates, data interface{}) (string, bool, error) {
+	var buf bytes.Buffer
+	if templates.text != nil && templates.text.Lookup(subjectTemplateName) != nil {
+		if err := templates.text.ExecuteTemplate(&buf, subjectTemplateName, data); err != nil {
+			return "", false, err
+		}
+		return strings.TrimSpace(buf.String()), true, nil
+	}
+	if templates.html.Lookup(subjectTemplateName) != nil {
+		if err := templates.html.ExecuteTemplate(&buf, subjectTemplateName, data); err != nil {
+			return "", false, err
+		}
+		// the subject is not html, undo the escaping
+		return strings.TrimSpace(html.UnescapeString(buf.String())), true, nil
+	}
+	return "", false, nil
+}
+
+func (r *MessageRenderer) templates(name string) (*renderTemplates, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, found := r.cache[name]; found && !r.NoCache {
+		return t, nil
+	}
+	t, err := r.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	if r.cache == nil {
+		r.cache = make(map[string]*renderTemplates)
+	}
+	r.cache[name] = t
+	return t, nil
+}
+
+func (r *MessageRenderer) parse(name string) (*renderTemplates, error) {
+	if r.FS == nil {
+		return nil, errors.New("MessageRenderer has no FS")
+	}
+	var htmlPartials, textPartials []string
+	for _, pattern := range r.Partials {
+		matches, err := fs.Glob(r.FS, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			switch {
+			case strings.HasSuffix(match, ".html"):
+				htmlPartials = append(htmlPartials, match)
+			case strings.HasSuffix(match, ".txt"):
+				textPartials = append(textPartials, match)
+			}
+		}
+	}
+	t := &renderTemplates{}
+	var err error
+	t.html, err = htmltemplate.New(path.Base(name)+".html").Funcs(htmltemplate.FuncMap(r.Funcs)).ParseFS(r.FS, append([]string{name + ".html"}, htmlPartials...)...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s.html: %v", name, err)
+	}
+	if _, err := fs.Stat(r.FS, name+".txt"); err == nil {
+		t.text, err = texttemplate.New(path.Base(name)+".txt").Funcs(texttemplate.FuncMap(r.Funcs)).ParseFS(r.FS, append([]string{name + ".txt"}, textPartials...)...)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s.txt: %v", name, err)
+		}
+	}
+	return t, nil
+}
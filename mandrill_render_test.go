@@ -0,0 +1,54 @@
+package gochimp
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var renderFS = fstest.MapFS{
+	"layout.html": {Data: []byte(`{{define "layout"}}<html><head><style>p{color:red}</style></head><body>{{template "content" .}}</body></html>{{end}}`)},
+	"welcome.html": {Data: []byte(`{{define "subject"}}Welcome {{.Name}} & co{{end}}` +
+		`{{define "content"}}<p>Hi {{.Name}},</p><p>Your <b>plan</b> is {{.Plan}}.</p>{{end}}{{template "layout" .}}`)},
+	"receipt.html": {Data: []byte(`<p>Total: {{.Total}}</p>`)},
+	"receipt.txt":  {Data: []byte(`{{define "subject"}}Receipt for {{.Name}}{{end}}Total: {{.Total}}`)},
+}
+
+func TestMessageRendererGeneratesText(t *testing.T) {
+	renderer := NewMessageRenderer(renderFS)
+	renderer.Partials = []string{"layout.html"}
+	var message Message
+	if err := renderer.Render(&message, "welcome", map[string]string{"Name": "<Ann>", "Plan": "pro"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(message.Html, "<p>Hi &lt;Ann&gt;,</p>") {
+		t.Errorf("html was not escaped: %s", message.Html)
+	}
+	if message.Subject != "Welcome <Ann> & co" {
+		t.Errorf("wrong subject %q", message.Subject)
+	}
+	if message.Text != "Hi <Ann>,\n\nYour plan is pro." {
+		t.Errorf("wrong generated text %q", message.Text)
+	}
+}
+
+func TestMessageRendererTextTemplate(t *testing.T) {
+	renderer := NewMessageRenderer(renderFS)
+	renderer.CSSInliner = func(html string) (string, error) {
+		return strings.Replace(html, "<p>", `<p style="margin:0">`, -1), nil
+	}
+	message := Message{Subject: "unchanged"}
+	data := struct {
+		Name  string
+		Total string
+	}{"Ann", "$10"}
+	if err := renderer.Render(&message, "receipt", data); err != nil {
+		t.Fatal(err)
+	}
+	if message.Html != `<p style="margin:0">Total: $10</p>` || message.Text != "Total: $10" || message.Subject != "Receipt for Ann" {
+		t.Errorf("wrong message %+v", message)
+	}
+	if err := renderer.Render(&message, "missing", data); err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
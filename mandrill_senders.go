@@ -17,10 +17,13 @@ import (
 )
 
 // see https://mandrillapp.com/api/docs/senders.html
-const senders_list_endpoint string = "/senders/list.json"               //Return the senders that have tried to use this account.
-const senders_domains_endpoint string = "/senders/domains.json"         //Returns the sender domains that have been added to this account.
-const senders_info_endpoint string = "/senders/info.json"               //Return more detailed information about a single sender, including aggregates of recent stats
-const senders_time_series_endpoint string = "/senders/time-series.json" //Return the recent history (hourly stats for the last 30 days) for a sender
+const senders_list_endpoint string = "/senders/list.json"                   //Return the senders that have tried to use this account.
+const senders_domains_endpoint string = "/senders/domains.json"             //Returns the sender domains that have been added to this account.
+const senders_info_endpoint string = "/senders/info.json"                   //Return more detailed information about a single sender, including aggregates of recent stats
+const senders_time_series_endpoint string = "/senders/time-series.json"     //Return the recent history (hourly stats for the last 30 days) for a sender
+const senders_add_domain_endpoint string = "/senders/add-domain.json"       //Adds a sender domain to your account.
+const senders_check_domain_endpoint string = "/senders/check-domain.json"   //Checks the SPF and DKIM settings for a domain.
+const senders_verify_domain_endpoint string = "/senders/verify-domain.json" //Sends a verification email in order to verify ownership of a domain.
 
 // can error with one of the following: Invalid_Key, ValidationError, GeneralError
 func (a *MandrillAPI) SenderList() ([]Sender, error) {
@@ -62,6 +65,52 @@ func (a *MandrillAPI) SenderTimeSeries(address string) ([]TimeSeries, error) {
 	return response, err
 }
 
+// SenderDomainAdd adds a sender domain to the account. Adding a domain that has
+// already been added returns the existing domain.
+//
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) SenderDomainAdd(domain string) (Domain, error) {
+	return getSenderDomain(a, domain, senders_add_domain_endpoint)
+}
+
+// SenderDomainCheck checks the SPF and DKIM settings for a domain. If the domain
+// has not been added to the account it is added as a side effect.
+//
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) SenderDomainCheck(domain string) (Domain, error) {
+	return getSenderDomain(a, domain, senders_check_domain_endpoint)
+}
+
+// SenderDomainVerify sends a verification email to mailbox@domain. Following the
+// link in that email marks the domain as verified.
+//
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) SenderDomainVerify(domain string, mailbox string) (DomainVerification, error) {
+	var response DomainVerification
+	if domain == "" {
+		return response, errors.New("domain cannot be blank")
+	}
+	if mailbox == "" {
+		return response, errors.New("mailbox cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["domain"] = domain
+	params["mailbox"] = mailbox
+	err := parseMandrillJson(a, senders_verify_domain_endpoint, params, &response)
+	return response, err
+}
+
+func getSenderDomain(a *MandrillAPI, domain string, endpoint string) (Domain, error) {
+	var response Domain
+	if domain == "" {
+		return response, errors.New("domain cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["domain"] = domain
+	err := parseMandrillJson(a, endpoint, params, &response)
+	return response, err
+}
+
 type SenderInfo struct {
 	Address     string    `json:"address"`
 	CreatedAt   time.Time `json:"created_at"`
@@ -77,6 +126,30 @@ type SenderInfo struct {
 }
 
 type Domain struct {
-	Domain    string    `json:"domain"`
-	CreatedAt time.Time `json:"created_at"`
+	Domain       string          `json:"domain"`
+	CreatedAt    APITime         `json:"created_at"`
+	LastTestedAt APITime         `json:"last_tested_at"`
+	Spf          DomainDnsRecord `json:"spf"`
+	Dkim         DomainDnsRecord `json:"dkim"`
+	VerifiedAt   APITime         `json:"verified_at"`
+	ValidSigning bool            `json:"valid_signing"`
+}
+
+// Verified reports whether ownership of the domain has been confirmed.
+func (d Domain) Verified() bool {
+	return !d.VerifiedAt.IsZero()
+}
+
+// DomainDnsRecord is the result of Mandrill's last check of a domain's SPF or
+// DKIM record.
+type DomainDnsRecord struct {
+	Valid      bool    `json:"valid"`
+	ValidAfter APITime `json:"valid_after"`
+	Error      string  `json:"error"`
+}
+
+type DomainVerification struct {
+	Status string `json:"status"`
+	Domain string `json:"domain"`
+	Email  string `json:"email"`
 }
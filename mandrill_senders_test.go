@@ -0,0 +1,73 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSenderDomains(t *testing.T) {
+	const checked = `{"domain":"example.com","created_at":"2013-01-01 15:30:27","last_tested_at":"2013-01-01 15:40:42",
+		"spf":{"valid":false,"valid_after":"2013-01-01 15:45:23","error":"did not match include:spf.mandrillapp.com"},
+		"dkim":{"valid":true,"valid_after":null,"error":""},
+		"verified_at":"2013-01-01 15:50:19","valid_signing":true}`
+	api, calls, stop := fakeMandrillServer(map[string]string{
+		senders_domains_endpoint:       `[{"domain":"example.org","created_at":"2013-01-01 15:30:27","spf":{"valid":true},"dkim":{"valid":true},"verified_at":null}]`,
+		senders_add_domain_endpoint:    checked,
+		senders_check_domain_endpoint:  checked,
+		senders_verify_domain_endpoint: `{"status":"sent","domain":"example.com","email":"postmaster@example.com"}`,
+	})
+	defer stop()
+
+	domains, err := api.SenderDomains()
+	if err != nil || len(domains) != 1 || domains[0].Verified() || !domains[0].Spf.Valid {
+		t.Errorf("SenderDomains %+v %v", domains, err)
+	}
+	if _, err := api.SenderDomainAdd("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	domain, err := api.SenderDomainCheck("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !domain.Verified() || !domain.VerifiedAt.Equal(time.Date(2013, 1, 1, 15, 50, 19, 0, time.UTC)) || !domain.ValidSigning ||
+		!domain.LastTestedAt.Equal(time.Date(2013, 1, 1, 15, 40, 42, 0, time.UTC)) {
+		t.Errorf("wrong domain %+v", domain)
+	}
+	spf := DomainDnsRecord{Valid: false, ValidAfter: APITime{time.Date(2013, 1, 1, 15, 45, 23, 0, time.UTC)},
+		Error: "did not match include:spf.mandrillapp.com"}
+	if domain.Spf != spf || domain.Dkim != (DomainDnsRecord{Valid: true}) {
+		t.Errorf("wrong dns records %+v %+v", domain.Spf, domain.Dkim)
+	}
+	verification, err := api.SenderDomainVerify("example.com", "postmaster")
+	if err != nil || verification.Status != "sent" || verification.Email != "postmaster@example.com" {
+		t.Errorf("SenderDomainVerify %+v %v", verification, err)
+	}
+
+	expected := []mandrillCall{
+		{senders_domains_endpoint, map[string]interface{}{}},
+		{senders_add_domain_endpoint, map[string]interface{}{"domain": "example.com"}},
+		{senders_check_domain_endpoint, map[string]interface{}{"domain": "example.com"}},
+		{senders_verify_domain_endpoint, map[string]interface{}{"domain": "example.com", "mailbox": "postmaster"}},
+	}
+	if !reflect.DeepEqual(*calls, expected) {
+		t.Errorf("wrong calls\n got %+v\nwant %+v", *calls, expected)
+	}
+	if _, err := api.SenderDomainVerify("example.com", ""); err == nil {
+		t.Error("expected a blank mailbox error")
+	}
+	if _, err := api.SenderDomainCheck(""); err == nil || len(*calls) != len(expected) {
+		t.Error("expected a blank domain error")
+	}
+}
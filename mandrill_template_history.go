@@ -0,0 +1,277 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the actions recorded in a template history
+const (
+	TemplateHistoryAdd      = "add"
+	TemplateHistoryUpdate   = "update"
+	TemplateHistoryPublish  = "publish"
+	TemplateHistoryRollback = "rollback"
+)
+
+// TemplateContent is the part of a template that is versioned.
+type TemplateContent struct {
+	Code      string   `json:"code"`
+	Text      string   `json:"text"`
+	Subject   string   `json:"subject"`
+	FromEmail string   `json:"from_email"`
+	FromName  string   `json:"from_name"`
+	Labels    []string `json:"labels,omitempty"`
+}
+
+// TemplateVersion is one change made to a template through gochimp. For add
+// and update it holds the resulting draft, for publish and rollback the
+// resulting published content.
+type TemplateVersion struct {
+	Name       string          `json:"name"`
+	Version    int             `json:"version"`
+	Action     string          `json:"action"`
+	RecordedAt time.Time       `json:"recorded_at"`
+	Content    TemplateContent `json:"content"`
+}
+
+// TemplateHistoryStore keeps the versions of templates. Set it on
+// MandrillAPI.TemplateHistory to record every TemplateAdd, TemplateUpdate and
+// TemplatePublish made through that client.
+type TemplateHistoryStore interface {
+	// Record stores a new version, numbering it after the last one of the same
+	// template, and returns it with Version set.
+	Record(version TemplateVersion) (TemplateVersion, error)
+	// Versions returns every version of a template, oldest first.
+	Versions(name string) ([]TemplateVersion, error)
+}
+
+func templateVersionOf(t Template, action string) TemplateVersion {
+	v := TemplateVersion{Name: t.Name, Action: action, RecordedAt: time.Now().UTC()}
+	switch action {
+	case TemplateHistoryPublish, TemplateHistoryRollback:
+		v.Content = TemplateContent{Code: t.PublishCode, Text: t.PublishText, Subject: t.PublishSubject,
+			FromEmail: t.PublishFromEmail, FromName: t.PublishFromName, Labels: t.Labels}
+	default:
+		v.Content = TemplateContent{Code: t.Code, Text: t.Text, Subject: t.Subject,
+			FromEmail: t.FromEmail, FromName: t.FromName, Labels: t.Labels}
+	}
+	return v
+}
+
+// recordTemplate saves a template returned by the API in the history store,
+// when one is set.
+func (a *MandrillAPI) recordTemplate(t Template, err error, action string) (Template, error) {
+	if err != nil || a.TemplateHistory == nil {
+		return t, err
+	}
+	if _, err := a.TemplateHistory.Record(templateVersionOf(t, action)); err != nil {
+		return t, fmt.Errorf("template %s was changed but its history was not recorded: %v", t.Name, err)
+	}
+	return t, nil
+}
+
+// TemplateVersionInfo returns a single version from the history store.
+func (a *MandrillAPI) TemplateVersionInfo(name string, version int) (TemplateVersion, error) {
+	if a.TemplateHistory == nil {
+		return TemplateVersion{}, errors.New("no template history store configured")
+	}
+	versions, err := a.TemplateHistory.Versions(name)
+	if err != nil {
+		return TemplateVersion{}, err
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return TemplateVersion{}, fmt.Errorf("template %s has no version %d", name, version)
+}
+
+// TemplateRollback restores the content of an earlier version of a template
+// and publishes it. The rollback itself is recorded as a new version.
+//
+// can error with one of the following: Unknown_Template, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) TemplateRollback(name string, version int) (Template, error) {
+	v, err := a.TemplateVersionInfo(name, version)
+	if err != nil {
+		return Template{}, err
+	}
+	opts := TemplateOptions{
+		Code:      StringPtr(v.Content.Code),
+		Text:      StringPtr(v.Content.Text),
+		Subject:   StringPtr(v.Content.Subject),
+		FromEmail: StringPtr(v.Content.FromEmail),
+		FromName:  StringPtr(v.Content.FromName),
+		Labels:    v.Content.Labels,
+		Publish:   true,
+	}
+	if opts.Labels == nil {
+		opts.Labels = []string{}
+	}
+	return a.templateUpdate(name, opts, TemplateHistoryRollback)
+}
+
+// DiffTemplateVersions describes what changed between two versions, one line
+// per changed field followed by a line diff of the code and text.
+func DiffTemplateVersions(from TemplateVersion, to TemplateVersion) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s v%d (%s)\n+++ %s v%d (%s)\n", from.Name, from.Version, from.Action, to.Name, to.Version, to.Action)
+	fields := []struct {
+		name     string
+		from, to string
+	}{
+		{"subject", from.Content.Subject, to.Content.Subject},
+		{"from_email", from.Content.FromEmail, to.Content.FromEmail},
+		{"from_name", from.Content.FromName, to.Content.FromName},
+		{"labels", strings.Join(from.Content.Labels, ","), strings.Join(to.Content.Labels, ",")},
+	}
+	for _, f := range fields {
+		if f.from != f.to {
+			fmt.Fprintf(&buf, "%s: %q -> %q\n", f.name, f.from, f.to)
+		}
+	}
+	if from.Content.Code != to.Content.Code {
+		fmt.Fprintln(&buf, "@@ code @@")
+		writeLineDiff(&buf, from.Content.Code, to.Content.Code)
+	}
+	if from.Content.Text != to.Content.Text {
+		fmt.Fprintln(&buf, "@@ text @@")
+		writeLineDiff(&buf, from.Content.Text, to.Content.Text)
+	}
+	return buf.String()
+}
+
+// writeLineDiff writes the changed lines between a and b, prefixed with - and
+// +, using the longest common subsequence of lines.
+func writeLineDiff(buf *bytes.Buffer, a string, b string) {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] > lcs[i+1][j]):
+			fmt.Fprintf(buf, "+%s\n", y[j])
+			j++
+		default:
+			fmt.Fprintf(buf, "-%s\n", x[i])
+			i++
+		}
+	}
+}
+
+// FileTemplateHistory is a TemplateHistoryStore that appends the versions of
+// each template as JSON lines to a file of its own in Dir.
+type FileTemplateHistory struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewFileTemplateHistory returns a store writing to dir, creating it if needed.
+func NewFileTemplateHistory(dir string) (*FileTemplateHistory, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileTemplateHistory{Dir: dir}, nil
+}
+
+func (h *FileTemplateHistory) path(name string) string {
+	return filepath.Join(h.Dir, url.PathEscape(name)+".jsonl")
+}
+
+func (h *FileTemplateHistory) Record(version TemplateVersion) (TemplateVersion, error) {
+	if version.Name == "" {
+		return version, errors.New("name cannot be blank")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	versions, err := h.versions(version.Name)
+	if err != nil {
+		return version, err
+	}
+	version.Version = len(versions) + 1
+	if version.RecordedAt.IsZero() {
+		version.RecordedAt = time.Now().UTC()
+	}
+	b, err := json.Marshal(version)
+	if err != nil {
+		return version, err
+	}
+	f, err := os.OpenFile(h.path(version.Name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return version, err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return version, err
+	}
+	return version, f.Close()
+}
+
+func (h *FileTemplateHistory) Versions(name string) ([]TemplateVersion, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.versions(name)
+}
+
+func (h *FileTemplateHistory) versions(name string) ([]TemplateVersion, error) {
+	f, err := os.Open(h.path(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var versions []TemplateVersion
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var v TemplateVersion
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, scanner.Err()
+}
@@ -0,0 +1,81 @@
+package gochimp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeTemplateServer answers the template endpoints the way Mandrill does,
+// keeping a single template in memory.
+func fakeTemplateServer(t *testing.T) (*MandrillAPI, func()) {
+	var current Template
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&params)
+		str := func(key string, dst *string) {
+			if v, ok := params[key].(string); ok {
+				*dst = v
+			}
+		}
+		current.Name = params["name"].(string)
+		str("code", &current.Code)
+		str("subject", &current.Subject)
+		str("text", &current.Text)
+		if strings.HasSuffix(r.URL.Path, "/publish.json") || params["publish"] == true {
+			current.PublishCode, current.PublishSubject, current.PublishText = current.Code, current.Subject, current.Text
+		}
+		json.NewEncoder(w).Encode(current)
+	}))
+	history, err := NewFileTemplateHistory(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &MandrillAPI{endpoint: srv.URL, TemplateHistory: history}, srv.Close
+}
+
+func TestTemplateHistoryRollback(t *testing.T) {
+	api, closer := fakeTemplateServer(t)
+	defer closer()
+	if _, err := api.TemplateAdd("welcome", "<p>one</p>\n<p>footer</p>", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.TemplatePublish("welcome"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.TemplateUpdateWithOptions("welcome", TemplateOptions{Code: StringPtr("<p>two</p>\n<p>footer</p>"), Subject: StringPtr("Hi"), Publish: true}); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := api.TemplateHistory.Versions("welcome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[1].Action != TemplateHistoryPublish || versions[2].Version != 3 {
+		t.Fatalf("wrong history %+v", versions)
+	}
+
+	diff := DiffTemplateVersions(versions[1], versions[2])
+	expected := "--- welcome v2 (publish)\n+++ welcome v3 (update)\n" +
+		"subject: \"\" -> \"Hi\"\n" +
+		"@@ code @@\n-<p>one</p>\n+<p>two</p>\n"
+	if diff != expected {
+		t.Errorf("wrong diff, expected:\n%s\ngot:\n%s", expected, diff)
+	}
+
+	template, err := api.TemplateRollback("welcome", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.PublishCode != "<p>one</p>\n<p>footer</p>" || template.PublishSubject != "" {
+		t.Errorf("rollback did not republish version 2, got %+v", template)
+	}
+	versions, _ = api.TemplateHistory.Versions("welcome")
+	if len(versions) != 4 || versions[3].Action != TemplateHistoryRollback {
+		t.Errorf("rollback was not recorded, got %+v", versions)
+	}
+	if _, err := api.TemplateRollback("welcome", 9); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// the operations a TemplateSyncAction can perform
+const (
+	TemplateSyncCreate  = "create"
+	TemplateSyncUpdate  = "update"
+	TemplateSyncPublish = "publish"
+	TemplateSyncDelete  = "delete"
+)
+
+// LocalTemplate is a template kept outside of Mandrill, usually read from a
+// directory with ReadTemplateDir. Options left nil are not managed by the sync.
+type LocalTemplate struct {
+	Name    string
+	Options TemplateOptions
+	// Draft templates are only updated, never published
+	Draft bool
+}
+
+// templateSidecar is the format of the optional <name>.json file that sits next
+// to <name>.html.
+type templateSidecar struct {
+	Name      string   `json:"name"`
+	Subject   *string  `json:"subject"`
+	FromEmail *string  `json:"from_email"`
+	FromName  *string  `json:"from_name"`
+	Labels    []string `json:"labels"`
+	Draft     bool     `json:"draft"`
+}
+
+// ReadTemplateDir reads the templates at the root of fsys. Every <name>.html
+// file is a template, with its plain text part in an optional <name>.txt and
+// its subject, from_email, from_name, labels and draft flag in an optional
+// <name>.json. The sidecar may also rename the template with "name".
+func ReadTemplateDir(fsys fs.FS) ([]LocalTemplate, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files[entry.Name()] = true
+		}
+	}
+	var templates []LocalTemplate
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		base := strings.TrimSuffix(entry.Name(), ext)
+		switch {
+		case entry.IsDir():
+			continue
+		case ext == ".txt" || ext == ".json":
+			if !files[base+".html"] {
+				return nil, fmt.Errorf("%s has no matching %s.html", entry.Name(), base)
+			}
+			continue
+		case ext != ".html":
+			continue
+		}
+		local := LocalTemplate{Name: base}
+		code, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		local.Options.Code = StringPtr(string(code))
+		if files[base+".txt"] {
+			text, err := fs.ReadFile(fsys, base+".txt")
+			if err != nil {
+				return nil, err
+			}
+			local.Options.Text = StringPtr(string(text))
+		}
+		if files[base+".json"] {
+			b, err := fs.ReadFile(fsys, base+".json")
+			if err != nil {
+				return nil, err
+			}
+			var sidecar templateSidecar
+			if err := json.Unmarshal(b, &sidecar); err != nil {
+				return nil, fmt.Errorf("%s.json: %v", base, err)
+			}
+			if sidecar.Name != "" {
+				local.Name = sidecar.Name
+			}
+			local.Options.Subject = sidecar.Subject
+			local.Options.FromEmail = sidecar.FromEmail
+			local.Options.FromName = sidecar.FromName
+			local.Options.Labels = sidecar.Labels
+			local.Draft = sidecar.Draft
+		}
+		templates = append(templates, local)
+	}
+	return templates, nil
+}
+
+// TemplateSyncOptions controls how local templates are reconciled with Mandrill.
+type TemplateSyncOptions struct {
+	// DryRun only computes the plan, nothing is changed in Mandrill
+	DryRun bool
+	// DeleteOrphans deletes the templates in Mandrill that have no local copy
+	DeleteOrphans bool
+	// Label restricts the sync to templates carrying this label. It is added to
+	// every template the sync creates or updates, and only templates carrying
+	// it are considered orphans.
+	Label string
+}
+
+// TemplateSyncAction is one step of a TemplateSyncPlan.
+type TemplateSyncAction struct {
+	Op   string
+	Name string
+	// Changes lists the fields that differ, "code" or "published code" for example
+	Changes []string
+	Local   *LocalTemplate `json:"-"`
+}
+
+func (a TemplateSyncAction) String() string {
+	if len(a.Changes) == 0 {
+		return fmt.Sprintf("%s %s", a.Op, a.Name)
+	}
+	return fmt.Sprintf("%s %s (%s)", a.Op, a.Name, strings.Join(a.Changes, ", "))
+}
+
+// TemplateSyncPlan is the list of changes needed to bring Mandrill in line with
+// the local templates. Its String form is meant to be pasted into a code review.
+type TemplateSyncPlan struct {
+	Actions   []TemplateSyncAction
+	Unchanged []string
+}
+
+// Empty reports whether Mandrill is already in sync.
+func (p TemplateSyncPlan) Empty() bool {
+	return len(p.Actions) == 0
+}
+
+func (p TemplateSyncPlan) String() string {
+	var buf bytes.Buffer
+	for _, action := range p.Actions {
+		fmt.Fprintln(&buf, action)
+	}
+	fmt.Fprintf(&buf, "%d to change, %d unchanged\n", len(p.Actions), len(p.Unchanged))
+	return buf.String()
+}
+
+// PlanTemplateSync compares local templates to the remote ones returned by
+// TemplateList. A template whose draft differs is updated, one whose draft
+// matches but was never published with it is published.
+func PlanTemplateSync(local []LocalTemplate, remote []Template, opts TemplateSyncOptions) TemplateSyncPlan {
+	var plan TemplateSyncPlan
+	byName := make(map[string]Template, len(remote))
+	for _, template := range remote {
+		byName[template.Name] = template
+		if _, found := byName[template.Slug]; !found && template.Slug != "" {
+			byName[template.Slug] = template
+		}
+	}
+	seen := make(map[string]bool)
+	for i := range local {
+		l := local[i]
+		r, found := byName[l.Name]
+		if opts.Label != "" {
+			labels := l.Options.Labels
+			if labels == nil && found {
+				// labels are not managed locally, keep the remote ones
+				labels = r.Labels
+			}
+			if !hasString(labels, opts.Label) {
+				labels = append(append([]string{}, labels...), opts.Label)
+			}
+			l.Options.Labels = labels
+		}
+		if !found {
+			plan.Actions = append(plan.Actions, TemplateSyncAction{Op: TemplateSyncCreate, Name: l.Name, Local: &l})
+			continue
+		}
+		seen[r.Name] = true
+		if changes := templateDraftChanges(l.Options, r); len(changes) > 0 {
+			plan.Actions = append(plan.Actions, TemplateSyncAction{Op: TemplateSyncUpdate, Name: r.Name, Changes: changes, Local: &l})
+			continue
+		}
+		if changes := templatePublishedChanges(l.Options, r); !l.Draft && len(changes) > 0 {
+			plan.Actions = append(plan.Actions, TemplateSyncAction{Op: TemplateSyncPublish, Name: r.Name, Changes: changes, Local: &l})
+			continue
+		}
+		plan.Unchanged = append(plan.Unchanged, r.Name)
+	}
+	if opts.DeleteOrphans {
+		var orphans []string
+		for _, r := range remote {
+			if seen[r.Name] || (opts.Label != "" && !hasString(r.Labels, opts.Label)) {
+				continue
+			}
+			orphans = append(orphans, r.Name)
+		}
+		sort.Strings(orphans)
+		for _, name := range orphans {
+			plan.Actions = append(plan.Actions, TemplateSyncAction{Op: TemplateSyncDelete, Name: name})
+		}
+	}
+	return plan
+}
+
+// TemplateSync reads the templates in fsys with ReadTemplateDir and brings
+// Mandrill in line with them. The plan is returned even when it was only
+// partly applied, so callers can tell how far it got.
+func (a *MandrillAPI) TemplateSync(fsys fs.FS, opts TemplateSyncOptions) (TemplateSyncPlan, error) {
+	local, err := ReadTemplateDir(fsys)
+	if err != nil {
+		return TemplateSyncPlan{}, err
+	}
+	// every template is listed, not only the labelled ones, so that a template
+	// that lost its label is updated rather than created again
+	remote, err := a.TemplateList()
+	if err != nil {
+		return TemplateSyncPlan{}, err
+	}
+	plan := PlanTemplateSync(local, remote, opts)
+	if opts.DryRun {
+		return plan, nil
+	}
+	return plan, a.TemplateSyncApply(plan)
+}
+
+// TemplateSyncApply performs every action of a plan in order, stopping at the
+// first error.
+func (a *MandrillAPI) TemplateSyncApply(plan TemplateSyncPlan) error {
+	for _, action := range plan.Actions {
+		var err error
+		switch action.Op {
+		case TemplateSyncCreate:
+			opts := action.Local.Options
+			opts.Publish = !action.Local.Draft
+			_, err = a.TemplateAddWithOptions(action.Name, opts)
+		case TemplateSyncUpdate:
+			opts := action.Local.Options
+			opts.Publish = !action.Local.Draft
+			_, err = a.TemplateUpdateWithOptions(action.Name, opts)
+		case TemplateSyncPublish:
+			_, err = a.TemplatePublish(action.Name)
+		case TemplateSyncDelete:
+			_, err = a.TemplateDelete(action.Name)
+		default:
+			err = fmt.Errorf("unknown template sync operation %q", action.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", action, err)
+		}
+	}
+	return nil
+}
+
+func templateDraftChanges(o TemplateOptions, t Template) []string {
+	var changes []string
+	changes = appendChange(changes, "code", o.Code, t.Code)
+	changes = appendChange(changes, "text", o.Text, t.Text)
+	changes = appendChange(changes, "subject", o.Subject, t.Subject)
+	changes = appendChange(changes, "from_email", o.FromEmail, t.FromEmail)
+	changes = appendChange(changes, "from_name", o.FromName, t.FromName)
+	if o.Labels != nil && !sameStrings(o.Labels, t.Labels) {
+		changes = append(changes, "labels")
+	}
+	return changes
+}
+
+func templatePublishedChanges(o TemplateOptions, t Template) []string {
+	var changes []string
+	changes = appendChange(changes, "published code", o.Code, t.PublishCode)
+	changes = appendChange(changes, "published text", o.Text, t.PublishText)
+	changes = appendChange(changes, "published subject", o.Subject, t.PublishSubject)
+	changes = appendChange(changes, "published from_email", o.FromEmail, t.PublishFromEmail)
+	changes = appendChange(changes, "published from_name", o.FromName, t.PublishFromName)
+	return changes
+}
+
+func appendChange(changes []string, field string, local *string, remote string) []string {
+	if local != nil && *local != remote {
+		return append(changes, field)
+	}
+	return changes
+}
+
+func hasString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package gochimp
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadTemplateDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"welcome.html":  {Data: []byte("<p>Hi *|FNAME|*</p>")},
+		"welcome.txt":   {Data: []byte("Hi *|FNAME|*")},
+		"welcome.json":  {Data: []byte(`{"subject": "Welcome", "labels": ["onboarding"]}`)},
+		"receipt.html":  {Data: []byte("<p>Thanks</p>")},
+		"receipt.json":  {Data: []byte(`{"name": "order-receipt", "draft": true}`)},
+		"README.md":     {Data: []byte("ignored")},
+		"partials/a.md": {Data: []byte("ignored")},
+	}
+	templates, err := ReadTemplateDir(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 2 {
+		t.Fatalf("expected 2 templates, got %d", len(templates))
+	}
+	receipt, welcome := templates[0], templates[1]
+	if receipt.Name != "order-receipt" || !receipt.Draft || receipt.Options.Text != nil || receipt.Options.Subject != nil {
+		t.Errorf("wrong receipt template %+v", receipt)
+	}
+	if welcome.Name != "welcome" || *welcome.Options.Text != "Hi *|FNAME|*" || *welcome.Options.Subject != "Welcome" {
+		t.Errorf("wrong welcome template %+v", welcome)
+	}
+
+	fsys["orphan.txt"] = &fstest.MapFile{Data: []byte("no html")}
+	if _, err := ReadTemplateDir(fsys); err == nil {
+		t.Error("expected an error for a text part without html")
+	}
+}
+
+func TestPlanTemplateSync(t *testing.T) {
+	local := []LocalTemplate{
+		{Name: "new", Options: TemplateOptions{Code: StringPtr("new")}},
+		{Name: "changed", Options: TemplateOptions{Code: StringPtr("v2"), Subject: StringPtr("Same")}},
+		{Name: "unpublished", Options: TemplateOptions{Code: StringPtr("v2")}},
+		{Name: "draft", Options: TemplateOptions{Code: StringPtr("v2")}, Draft: true},
+		{Name: "same", Options: TemplateOptions{Code: StringPtr("v1")}},
+	}
+	remote := []Template{
+		{Name: "changed", Code: "v1", PublishCode: "v1", Subject: "Same", Labels: []string{"app"}},
+		{Name: "unpublished", Code: "v2", PublishCode: "v1", Labels: []string{"app"}},
+		{Name: "draft", Code: "v2", PublishCode: "v1", Labels: []string{"app"}},
+		{Name: "same", Code: "v1", PublishCode: "v1", Labels: []string{"app"}},
+		{Name: "orphan", Labels: []string{"app"}},
+		{Name: "unmanaged"},
+	}
+	plan := PlanTemplateSync(local, remote, TemplateSyncOptions{DeleteOrphans: true, Label: "app"})
+	expected := "create new\n" +
+		"update changed (code)\n" +
+		"publish unpublished (published code)\n" +
+		"delete orphan\n" +
+		"4 to change, 2 unchanged\n"
+	if plan.String() != expected {
+		t.Errorf("wrong plan, expected:\n%s\ngot:\n%s", expected, plan)
+	}
+	if labels := plan.Actions[0].Local.Options.Labels; len(labels) != 1 || labels[0] != "app" {
+		t.Errorf("created template should carry the sync label, got %v", labels)
+	}
+}
@@ -21,7 +21,7 @@ const templates_add_endpoint string = "/templates/add.json"       //Add a new te
 const templates_info_endpoint string = "/templates/info.json"     //Get the information for an existing template
 const templates_update_endpoint string = "/templates/update.json" //Update the code for an existing template
 // Publish the content for the template. Any new messages sent using this template will start
-//using the content that was previously in draft.
+// using the content that was previously in draft.
 const templates_publish_endpoint string = "/templates/publish.json"
 const templates_delete_endpoint string = "/templates/delete.json"           //Delete a template
 const templates_list_endpoint string = "/templates/list.json"               //Return a list of all the templates available to this user
@@ -36,11 +36,20 @@ func (a *MandrillAPI) TemplateAdd(name string, code string, publish bool) (Templ
 	if code == "" {
 		return Template{}, errors.New("code cannot be blank")
 	}
-	var params map[string]interface{} = make(map[string]interface{})
+	return a.TemplateAddWithOptions(name, TemplateOptions{Code: &code, Publish: publish})
+}
+
+// TemplateAddWithOptions adds a template, setting every field given in opts.
+//
+// can error with one of the following: Invalid_Template, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) TemplateAddWithOptions(name string, opts TemplateOptions) (Template, error) {
+	if name == "" {
+		return Template{}, errors.New("name cannot be blank")
+	}
+	params := opts.params()
 	params["name"] = name
-	params["code"] = code
-	params["publish"] = publish
-	return execute(a, params, templates_add_endpoint)
+	t, err := execute(a, params, templates_add_endpoint)
+	return a.recordTemplate(t, err, TemplateHistoryAdd)
 }
 
 // can error with one of the following: Unknown_Template, Invalid_Key, ValidationError, GeneralError
@@ -61,11 +70,25 @@ func (a *MandrillAPI) TemplateUpdate(name string, code string, publish bool) (Te
 	if code == "" {
 		return Template{}, errors.New("code cannot be blank")
 	}
-	var params map[string]interface{} = make(map[string]interface{})
+	return a.TemplateUpdateWithOptions(name, TemplateOptions{Code: &code, Publish: publish})
+}
+
+// TemplateUpdateWithOptions updates the fields given in opts, fields left nil
+// keep their current value.
+//
+// can error with one of the following: Unknown_Template, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) TemplateUpdateWithOptions(name string, opts TemplateOptions) (Template, error) {
+	return a.templateUpdate(name, opts, TemplateHistoryUpdate)
+}
+
+func (a *MandrillAPI) templateUpdate(name string, opts TemplateOptions, action string) (Template, error) {
+	if name == "" {
+		return Template{}, errors.New("name cannot be blank")
+	}
+	params := opts.params()
 	params["name"] = name
-	params["code"] = code
-	params["publish"] = publish
-	return execute(a, params, templates_update_endpoint)
+	t, err := execute(a, params, templates_update_endpoint)
+	return a.recordTemplate(t, err, action)
 }
 
 // can error with one of the following: Unknown_Template, Invalid_Key, ValidationError, GeneralError
@@ -75,7 +98,8 @@ func (a *MandrillAPI) TemplatePublish(name string) (Template, error) {
 	}
 	var params map[string]interface{} = make(map[string]interface{})
 	params["name"] = name
-	return execute(a, params, templates_publish_endpoint)
+	t, err := execute(a, params, templates_publish_endpoint)
+	return a.recordTemplate(t, err, TemplateHistoryPublish)
 }
 
 // can error with one of the following: Unknown_Template, Invalid_Key, ValidationError, GeneralError
@@ -90,8 +114,19 @@ func (a *MandrillAPI) TemplateDelete(name string) (Template, error) {
 
 // can error with one of the following: Invalid_Key, ValidationError, GeneralError
 func (a *MandrillAPI) TemplateList() ([]Template, error) {
+	return a.TemplateListByLabel("")
+}
+
+// TemplateListByLabel returns the templates carrying the given label, or all of
+// them when label is blank.
+//
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) TemplateListByLabel(label string) ([]Template, error) {
 	var response []Template
 	var params map[string]interface{} = make(map[string]interface{})
+	if label != "" {
+		params["label"] = label
+	}
 	err := parseMandrillJson(a, templates_list_endpoint, params, &response)
 	return response, err
 }
@@ -136,21 +171,64 @@ func execute(a *MandrillAPI, params map[string]interface{}, endpoint string) (Te
 	return response, err
 }
 
+// TemplateOptions holds the fields of a template to add or update. Fields left
+// nil are not sent, so TemplateUpdateWithOptions leaves them untouched. A nil
+// Labels leaves the labels untouched while an empty one removes them all.
+type TemplateOptions struct {
+	FromEmail *string
+	FromName  *string
+	Subject   *string
+	Code      *string
+	Text      *string
+	Labels    []string
+	Publish   bool
+}
+
+// StringPtr returns a pointer to s, for filling in TemplateOptions.
+func StringPtr(s string) *string {
+	return &s
+}
+
+func (o TemplateOptions) params() map[string]interface{} {
+	var params map[string]interface{} = make(map[string]interface{})
+	if o.FromEmail != nil {
+		params["from_email"] = *o.FromEmail
+	}
+	if o.FromName != nil {
+		params["from_name"] = *o.FromName
+	}
+	if o.Subject != nil {
+		params["subject"] = *o.Subject
+	}
+	if o.Code != nil {
+		params["code"] = *o.Code
+	}
+	if o.Text != nil {
+		params["text"] = *o.Text
+	}
+	if o.Labels != nil {
+		params["labels"] = o.Labels
+	}
+	params["publish"] = o.Publish
+	return params
+}
+
 type Template struct {
-	Name             string  `json:"name"`
-	Code             string  `json:"code"`
-	PublishName      string  `json:"publish_name"`
-	PublishCode      string  `json:"publish_code"`
-	Slug             string  `json:"slug"`
-	Subject          string  `json:"subject"`
-	CreatedAt        APITime `json:"published_at"`
-	UpdateAt         APITime `json:"updated_at"`
-	FromEmail        string  `json:"from_email"`
-	FromName         string  `json:"from_name"`
-	Text             string  `json:"text"`
-	PublishFromEmail string  `json:"publish_from_email"`
-	PublishFromName  string  `json:"publish_from_name"`
-	PublishText      string  `json:"publish_text"`
-	PublishSubject   string  `json:"publish_subject"`
-	PublishAt        APITime `json:"published_at"`
+	Name             string   `json:"name"`
+	Code             string   `json:"code"`
+	PublishName      string   `json:"publish_name"`
+	PublishCode      string   `json:"publish_code"`
+	Slug             string   `json:"slug"`
+	Subject          string   `json:"subject"`
+	Labels           []string `json:"labels"`
+	CreatedAt        APITime  `json:"created_at"`
+	UpdateAt         APITime  `json:"updated_at"`
+	FromEmail        string   `json:"from_email"`
+	FromName         string   `json:"from_name"`
+	Text             string   `json:"text"`
+	PublishFromEmail string   `json:"publish_from_email"`
+	PublishFromName  string   `json:"publish_from_name"`
+	PublishText      string   `json:"publish_text"`
+	PublishSubject   string   `json:"publish_subject"`
+	PublishAt        APITime  `json:"published_at"`
 }
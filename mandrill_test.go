@@ -138,6 +138,37 @@ func TestTemplateUpdate(t *testing.T) {
 	mandrill.TemplateDelete("updateTest")
 }
 
+func TestTemplateUpdateWithOptions(t *testing.T) {
+	mandrill.TemplateDelete("updateOptionsTest")
+	template, err := mandrill.TemplateAddWithOptions("updateOptionsTest", TemplateOptions{
+		Code:    StringPtr("testing 123"),
+		Subject: StringPtr("Original subject"),
+		Labels:  []string{"gochimp-test"},
+	})
+	if err != nil {
+		t.Errorf("Error:%v", err)
+	}
+	// only the subject is given, the code and labels should be kept
+	template, err = mandrill.TemplateUpdateWithOptions("updateOptionsTest", TemplateOptions{Subject: StringPtr("New subject")})
+	if err != nil {
+		t.Errorf("Error:%v", err)
+	}
+	if template.Subject != "New subject" {
+		t.Errorf("Wrong template subject, expecting %s, got %s", "New subject", template.Subject)
+	}
+	if template.Code != "testing 123" {
+		t.Errorf("Wrong template code, expecting %s, got %s", "testing 123", template.Code)
+	}
+	templates, err := mandrill.TemplateListByLabel("gochimp-test")
+	if err != nil {
+		t.Errorf("Error:%v", err)
+	}
+	if len(templates) != 1 || templates[0].Name != "updateOptionsTest" {
+		t.Errorf("Expected only updateOptionsTest to be labelled, got %v", templates)
+	}
+	mandrill.TemplateDelete("updateOptionsTest")
+}
+
 func TestTemplatePublish(t *testing.T) {
 	mandrill.TemplateDelete("publishTest")
 	// add a simple template
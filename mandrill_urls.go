@@ -13,12 +13,17 @@ package gochimp
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 )
 
 // see https://mandrillapp.com/api/docs/urls.html
-const urls_list_endpoint string = "/urls/list.json"               //Get the 100 most clicked URLs
-const urls_search_endpoint string = "/urls/search.json"           //Return the 100 most clicked URLs that match the search query given
-const urls_time_series_endpoint string = "/urls/time-series.json" //Return the recent history (hourly stats for the last 30 days) for a url
+const urls_list_endpoint string = "/urls/list.json"                                   //Get the 100 most clicked URLs
+const urls_search_endpoint string = "/urls/search.json"                               //Return the 100 most clicked URLs that match the search query given
+const urls_time_series_endpoint string = "/urls/time-series.json"                     //Return the recent history (hourly stats for the last 30 days) for a url
+const urls_tracking_domains_endpoint string = "/urls/tracking-domains.json"           //Get the list of tracking domains set up for this account
+const urls_add_tracking_domain_endpoint string = "/urls/add-tracking-domain.json"     //Add a tracking domain to your account
+const urls_check_tracking_domain_endpoint string = "/urls/check-tracking-domain.json" //Checks the CNAME settings for a tracking domain.
 
 // can error with one of the following: Invalid_Key, ValidationError, GeneralError
 func (a *MandrillAPI) UrlList() ([]UrlInfo, error) {
@@ -52,6 +57,72 @@ func (a *MandrillAPI) UrlTimeSeries(url string) ([]UrlInfo, error) {
 	return response, err
 }
 
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) TrackingDomainList() ([]TrackingDomain, error) {
+	var response []TrackingDomain
+	var params map[string]interface{} = make(map[string]interface{})
+	err := parseMandrillJson(a, urls_tracking_domains_endpoint, params, &response)
+	return response, err
+}
+
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) TrackingDomainAdd(domain string) (TrackingDomain, error) {
+	return getTrackingDomain(a, domain, urls_add_tracking_domain_endpoint)
+}
+
+// TrackingDomainCheck asks Mandrill to re-check the CNAME of a tracking domain.
+// The domain must have been added with TrackingDomainAdd first.
+//
+// can error with one of the following: Unknown_TrackingDomain, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) TrackingDomainCheck(domain string) (TrackingDomain, error) {
+	return getTrackingDomain(a, domain, urls_check_tracking_domain_endpoint)
+}
+
+// VerifyTrackingDomain makes sure the TrackingDomain of a message is registered
+// on the account and has a valid CNAME, so that the message is not sent with
+// links Mandrill cannot rewrite. Messages without a TrackingDomain always pass.
+func (a *MandrillAPI) VerifyTrackingDomain(message Message) error {
+	if message.TrackingDomain == "" {
+		return nil
+	}
+	domains, err := a.TrackingDomainList()
+	if err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		if !strings.EqualFold(domain.Domain, message.TrackingDomain) {
+			continue
+		}
+		if !domain.ValidTracking {
+			if domain.Cname.Error != "" {
+				return fmt.Errorf("tracking domain %s is not valid: %s", message.TrackingDomain, domain.Cname.Error)
+			}
+			return fmt.Errorf("tracking domain %s is not valid", message.TrackingDomain)
+		}
+		return nil
+	}
+	return fmt.Errorf("tracking domain %s is not registered", message.TrackingDomain)
+}
+
+func getTrackingDomain(a *MandrillAPI, domain string, endpoint string) (TrackingDomain, error) {
+	var response TrackingDomain
+	if domain == "" {
+		return response, errors.New("domain cannot be blank")
+	}
+	var params map[string]interface{} = make(map[string]interface{})
+	params["domain"] = domain
+	err := parseMandrillJson(a, endpoint, params, &response)
+	return response, err
+}
+
+type TrackingDomain struct {
+	Domain        string          `json:"domain"`
+	CreatedAt     APITime         `json:"created_at"`
+	LastTestedAt  APITime         `json:"last_tested_at"`
+	Cname         DomainDnsRecord `json:"cname"`
+	ValidTracking bool            `json:"valid_tracking"`
+}
+
 type UrlTimeSeriesInfo struct {
 	Time         APITime `json:"time"`
 	Sent         int     `json:"sent"`
@@ -0,0 +1,84 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"reflect"
+	"testing"
+)
+
+const trackingDomains = `[
+	{"domain":"track.example.com","created_at":"2013-01-01 15:30:27","last_tested_at":"2013-01-01 15:40:42",
+		"cname":{"valid":true,"valid_after":null,"error":""},"valid_tracking":true},
+	{"domain":"links.example.com","cname":{"valid":false,"error":"CNAME does not point to mandrillapp.com"},"valid_tracking":false},
+	{"domain":"new.example.com","cname":{"valid":false},"valid_tracking":false}]`
+
+func TestTrackingDomains(t *testing.T) {
+	api, calls, stop := fakeMandrillServer(map[string]string{
+		urls_tracking_domains_endpoint:      trackingDomains,
+		urls_add_tracking_domain_endpoint:   `{"domain":"new.example.com","cname":{"valid":false},"valid_tracking":false}`,
+		urls_check_tracking_domain_endpoint: `{"domain":"new.example.com","cname":{"valid":true},"valid_tracking":true}`,
+	})
+	defer stop()
+	domains, err := api.TrackingDomainList()
+	if err != nil || len(domains) != 3 || !domains[0].Cname.Valid || domains[0].LastTestedAt.IsZero() {
+		t.Errorf("TrackingDomainList %+v %v", domains, err)
+	}
+	if domain, err := api.TrackingDomainAdd("new.example.com"); err != nil || domain.ValidTracking {
+		t.Errorf("TrackingDomainAdd %+v %v", domain, err)
+	}
+	if domain, err := api.TrackingDomainCheck("new.example.com"); err != nil || !domain.ValidTracking {
+		t.Errorf("TrackingDomainCheck %+v %v", domain, err)
+	}
+	expected := []mandrillCall{
+		{urls_tracking_domains_endpoint, map[string]interface{}{}},
+		{urls_add_tracking_domain_endpoint, map[string]interface{}{"domain": "new.example.com"}},
+		{urls_check_tracking_domain_endpoint, map[string]interface{}{"domain": "new.example.com"}},
+	}
+	if !reflect.DeepEqual(*calls, expected) {
+		t.Errorf("wrong calls\n got %+v\nwant %+v", *calls, expected)
+	}
+	if _, err := api.TrackingDomainAdd(""); err == nil {
+		t.Error("expected a blank domain error")
+	}
+}
+
+func TestVerifyTrackingDomain(t *testing.T) {
+	api, calls, stop := fakeMandrillServer(map[string]string{
+		urls_tracking_domains_endpoint: trackingDomains,
+	})
+	defer stop()
+	if err := api.VerifyTrackingDomain(Message{}); err != nil || len(*calls) != 0 {
+		t.Errorf("a message without tracking domain was checked: %v", err)
+	}
+	tests := map[string]string{
+		"TRACK.example.com":   "",
+		"links.example.com":   "tracking domain links.example.com is not valid: CNAME does not point to mandrillapp.com",
+		"new.example.com":     "tracking domain new.example.com is not valid",
+		"unknown.example.com": "tracking domain unknown.example.com is not registered",
+	}
+	for domain, expected := range tests {
+		got := ""
+		if err := api.VerifyTrackingDomain(Message{TrackingDomain: domain}); err != nil {
+			got = err.Error()
+		}
+		if got != expected {
+			t.Errorf("%s: got %q, expected %q", domain, got, expected)
+		}
+	}
+
+	failing, _, stop := fakeMandrillServer(map[string]string{})
+	defer stop()
+	if _, ok := failing.VerifyTrackingDomain(Message{TrackingDomain: "track.example.com"}).(MandrillError); !ok {
+		t.Error("expected the list error")
+	}
+}
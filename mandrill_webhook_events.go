@@ -0,0 +1,240 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// see https://mandrill.zendesk.com/hc/en-us/articles/205583307-Message-Event-Webhook-format
+// and https://mandrill.zendesk.com/hc/en-us/articles/205583297-Sync-Event-Webhook-format
+
+// the webhook event types, the values of WebhookEvent.Event and of the
+// events given to WebhookAdd
+const (
+	WebhookEventSend       = "send"
+	WebhookEventDeferral   = "deferral"
+	WebhookEventHardBounce = "hard_bounce"
+	WebhookEventSoftBounce = "soft_bounce"
+	WebhookEventOpen       = "open"
+	WebhookEventClick      = "click"
+	WebhookEventSpam       = "spam"
+	WebhookEventUnsub      = "unsub"
+	WebhookEventReject     = "reject"
+	WebhookEventInbound    = "inbound"
+	// sync events report changes to the whitelist and the rejection blacklist
+	WebhookEventWhitelist = "whitelist"
+	WebhookEventBlacklist = "blacklist"
+)
+
+// MandrillSignatureHeader is the header carrying the signature of a webhook post.
+const MandrillSignatureHeader = "X-Mandrill-Signature"
+
+// ErrInvalidSignature is returned for webhook posts whose signature does not match.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// WebhookEvent is one event of a Mandrill webhook batch.
+type WebhookEvent struct {
+	// Event is the event type, for sync events it is their Type, whitelist or blacklist
+	Event string `json:"event"`
+	Ts    TS     `json:"ts"`
+	// Id is the id of the message of message events
+	Id string `json:"_id"`
+	// Msg is the message of message events, as it was when the event happened
+	Msg *WebhookMessage `json:"-"`
+	// Inbound is the message received by inbound events
+	Inbound *InboundMessage `json:"-"`
+
+	// open and click events
+	Url             string            `json:"url"`
+	IP              string            `json:"ip"`
+	UserAgent       string            `json:"user_agent"`
+	UserAgentParsed *WebhookUserAgent `json:"user_agent_parsed"`
+	Location        *WebhookLocation  `json:"location"`
+
+	// sync events
+	Type   string                 `json:"type"`
+	Action string                 `json:"action"`
+	Reject *WebhookReject         `json:"reject"`
+	Entry  *WebhookWhitelistEntry `json:"entry"`
+
+	// Raw is the event as it was posted
+	Raw json.RawMessage `json:"-"`
+}
+
+func (e *WebhookEvent) UnmarshalJSON(data []byte) error {
+	// decode through another type, without this method
+	type event WebhookEvent
+	var decoded struct {
+		event
+		Msg json.RawMessage `json:"msg"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*e = WebhookEvent(decoded.event)
+	if e.Event == "" && e.Type != "" {
+		e.Event = e.Type
+	}
+	if len(decoded.Msg) > 0 && string(decoded.Msg) != "null" {
+		if e.Event == WebhookEventInbound {
+			e.Inbound = &InboundMessage{}
+			if err := json.Unmarshal(decoded.Msg, e.Inbound); err != nil {
+				return err
+			}
+		} else {
+			e.Msg = &WebhookMessage{}
+			if err := json.Unmarshal(decoded.Msg, e.Msg); err != nil {
+				return err
+			}
+		}
+	}
+	e.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// IsSync reports whether e is a sync event, a change to the whitelist or the blacklist.
+func (e WebhookEvent) IsSync() bool {
+	return e.Type != ""
+}
+
+// WebhookMessage is the message a message event is about.
+type WebhookMessage struct {
+	Ts                TS                     `json:"ts"`
+	Id                string                 `json:"_id"`
+	Version           string                 `json:"_version"`
+	Subject           string                 `json:"subject"`
+	Email             string                 `json:"email"`
+	Sender            string                 `json:"sender"`
+	Tags              []string               `json:"tags"`
+	State             string                 `json:"state"`
+	Metadata          map[string]interface{} `json:"metadata"`
+	Subaccount        string                 `json:"subaccount"`
+	Template          string                 `json:"template"`
+	Diag              string                 `json:"diag"`
+	BounceDescription string                 `json:"bounce_description"`
+	Opens             []ActivityDetail       `json:"opens"`
+	Clicks            []ActivityDetail       `json:"clicks"`
+	SMTPEvents        []SMTPEvent            `json:"smtp_events"`
+	Resends           []Resend               `json:"resends"`
+}
+
+// WebhookLocation is where an open or a click came from, located by IP.
+type WebhookLocation struct {
+	CountryShort string  `json:"country_short"`
+	Country      string  `json:"country"`
+	Region       string  `json:"region"`
+	City         string  `json:"city"`
+	PostalCode   string  `json:"postal_code"`
+	Timezone     string  `json:"timezone"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+}
+
+// WebhookUserAgent is the parsed user agent of an open or a click.
+type WebhookUserAgent struct {
+	Type         string `json:"type"`
+	UaFamily     string `json:"ua_family"`
+	UaName       string `json:"ua_name"`
+	UaVersion    string `json:"ua_version"`
+	UaUrl        string `json:"ua_url"`
+	UaCompany    string `json:"ua_company"`
+	UaCompanyUrl string `json:"ua_company_url"`
+	UaIcon       string `json:"ua_icon"`
+	OsFamily     string `json:"os_family"`
+	OsName       string `json:"os_name"`
+	OsUrl        string `json:"os_url"`
+	OsCompany    string `json:"os_company"`
+	OsCompanyUrl string `json:"os_company_url"`
+	OsIcon       string `json:"os_icon"`
+	Mobile       bool   `json:"mobile"`
+}
+
+// WebhookReject is the blacklist entry of a blacklist sync event.
+type WebhookReject struct {
+	Email       string  `json:"email"`
+	Reason      string  `json:"reason"`
+	Detail      string  `json:"detail"`
+	CreatedAt   APITime `json:"created_at"`
+	LastEventAt APITime `json:"last_event_at"`
+	ExpiresAt   APITime `json:"expires_at"`
+	Expired     bool    `json:"expired"`
+	Subaccount  string  `json:"subaccount"`
+	Sender      string  `json:"sender"`
+}
+
+// WebhookWhitelistEntry is the whitelist entry of a whitelist sync event.
+type WebhookWhitelistEntry struct {
+	Email     string  `json:"email"`
+	Detail    string  `json:"detail"`
+	CreatedAt APITime `json:"created_at"`
+}
+
+// ParseWebhookEvents decodes a batch of events, the mandrill_events field of a webhook post.
+func ParseWebhookEvents(mandrillEvents string) ([]WebhookEvent, error) {
+	if mandrillEvents == "" {
+		return nil, errors.New("mandrill_events cannot be blank")
+	}
+	var events []WebhookEvent
+	err := json.Unmarshal([]byte(mandrillEvents), &events)
+	return events, err
+}
+
+// ParseWebhookRequest verifies the signature of a webhook post and decodes its
+// events. webhookURL is the url the webhook was added with, exactly, and
+// authKey its Webhook.AuthKey. A blank authKey skips the verification.
+func ParseWebhookRequest(r *http.Request, authKey string, webhookURL string) ([]WebhookEvent, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	if authKey != "" && !VerifySignature(authKey, webhookURL, r.PostForm, r.Header.Get(MandrillSignatureHeader)) {
+		return nil, ErrInvalidSignature
+	}
+	return ParseWebhookEvents(r.PostForm.Get("mandrill_events"))
+}
+
+// WebhookSignature computes the X-Mandrill-Signature of a post: the base64
+// HMAC-SHA1, keyed with the auth key, of the webhook url followed by each
+// POST param name and value, sorted by name.
+func WebhookSignature(authKey string, webhookURL string, params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	mac := hmac.New(sha1.New, []byte(authKey))
+	mac.Write([]byte(webhookURL))
+	for _, key := range keys {
+		for _, value := range params[key] {
+			mac.Write([]byte(key))
+			mac.Write([]byte(value))
+		}
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the X-Mandrill-Signature of
+// a post of params to webhookURL, signed with authKey.
+func VerifySignature(authKey string, webhookURL string, params url.Values, signature string) bool {
+	if signature == "" {
+		return false
+	}
+	expected := WebhookSignature(authKey, webhookURL, params)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
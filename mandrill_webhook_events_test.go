@@ -0,0 +1,99 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const webhookBatch = `[
+{"event":"click","ts":1365111111,"_id":"exampleaaaaaaaaaaaaaaaaaaaaaaaaa",
+ "url":"http://mandrill.com","ip":"127.0.0.1","user_agent":"Mozilla/5.0",
+ "user_agent_parsed":{"type":"Browser","ua_family":"Firefox","mobile":false},
+ "location":{"country_short":"US","country":"United States","city":"Oklahoma City","latitude":35.4675598,"longitude":-97.5164261},
+ "msg":{"ts":1365109999,"_id":"exampleaaaaaaaaaaaaaaaaaaaaaaaaa","subject":"This an example webhook message","email":"example.webhook@mandrillapp.com",
+  "sender":"example.sender@mandrillapp.com","tags":["webhook-example"],"state":"sent","metadata":{"user_id":111},"template":null,
+  "opens":[{"ts":1365111111}],"clicks":[{"ts":1365111111,"url":"http://mandrill.com"}],
+  "smtp_events":[{"ts":1365109999,"type":"sent","diag":"250 OK"}]}},
+{"event":"hard_bounce","ts":1365109999,"_id":"examplebbbbbbbbbbbbbbbbbbbbbbbbb",
+ "msg":{"ts":1365109999,"_id":"examplebbbbbbbbbbbbbbbbbbbbbbbbb","email":"bounce@example.com","state":"bounced","diag":"smtp;550 5.1.1 user unknown","bounce_description":"bad_mailbox"}},
+{"type":"blacklist","action":"add","ts":1365109999,
+ "reject":{"reason":"hard-bounce","detail":"Example detail","last_event_at":"2014-02-01 12:43:56","email":"example.webhook@mandrillapp.com","created_at":"2014-01-15 11:32:19","expires_at":"2014-03-01 12:43:56","expired":false,"subaccount":null,"sender":null}},
+{"event":"inbound","ts":1365109999,
+ "msg":{"raw_msg":"Received: ...","email":"support@example.com","from_email":"someone@example.org","from_name":"Someone","to":[["support@example.com",null]],"subject":"Help","tags":[],"text":"hi"}}
+]`
+
+func TestParseWebhookEvents(t *testing.T) {
+	events, err := ParseWebhookEvents(webhookBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	click := events[0]
+	if click.Event != WebhookEventClick || click.Url != "http://mandrill.com" || click.Location.City != "Oklahoma City" ||
+		click.UserAgentParsed.UaFamily != "Firefox" || click.Ts.Unix() != 1365111111 {
+		t.Errorf("wrong click event %+v", click)
+	}
+	if click.Msg == nil || click.Msg.Metadata["user_id"] != float64(111) || len(click.Msg.Clicks) != 1 || click.Msg.SMTPEvents[0].Diagnostics != "250 OK" {
+		t.Errorf("wrong click msg %+v", click.Msg)
+	}
+	if !strings.HasPrefix(string(click.Raw), `{"event":"click"`) {
+		t.Errorf("raw event not kept: %s", click.Raw)
+	}
+	if bounce := events[1]; bounce.Event != WebhookEventHardBounce || bounce.Msg.BounceDescription != "bad_mailbox" {
+		t.Errorf("wrong bounce event %+v", bounce)
+	}
+	if sync := events[2]; !sync.IsSync() || sync.Event != WebhookEventBlacklist || sync.Action != "add" || sync.Reject.Reason != "hard-bounce" || sync.Reject.CreatedAt.Year() != 2014 {
+		t.Errorf("wrong sync event %+v", sync)
+	}
+	if inbound := events[3]; inbound.Msg != nil || inbound.Inbound == nil || inbound.Inbound.Email != "support@example.com" || inbound.Inbound.To[0][0] != "support@example.com" {
+		t.Errorf("wrong inbound event %+v", inbound)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	params := url.Values{"mandrill_events": {`[]`}}
+	signature := WebhookSignature("key", "http://example.com/webhook", params)
+	if !VerifySignature("key", "http://example.com/webhook", params, signature) {
+		t.Error("signature does not verify")
+	}
+	if VerifySignature("key", "http://example.com/webhook2", params, signature) || VerifySignature("other", "http://example.com/webhook", params, signature) {
+		t.Error("signature verifies with another url or key")
+	}
+	if VerifySignature("key", "http://example.com/webhook", params, "") {
+		t.Error("blank signature verifies")
+	}
+}
+
+func TestParseWebhookRequest(t *testing.T) {
+	const webhookURL = "https://example.com/mandrill"
+	form := url.Values{"mandrill_events": {webhookBatch}}
+	newRequest := func(signature string) *http.Request {
+		r := httptest.NewRequest("POST", webhookURL, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set(MandrillSignatureHeader, signature)
+		return r
+	}
+	events, err := ParseWebhookRequest(newRequest(WebhookSignature("secret", webhookURL, form)), "secret", webhookURL)
+	if err != nil || len(events) != 4 {
+		t.Errorf("got %d events, %v", len(events), err)
+	}
+	if _, err := ParseWebhookRequest(newRequest("bogus"), "secret", webhookURL); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature, got %v", err)
+	}
+}
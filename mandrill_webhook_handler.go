@@ -0,0 +1,181 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// DefaultWebhookConcurrency is the number of events a WebhookHandler handles
+// at once when its Concurrency is not set.
+const DefaultWebhookConcurrency = 4
+
+// WebhookEventFunc handles one webhook event. Returning an error makes the
+// whole batch fail, so that Mandrill posts it again later.
+type WebhookEventFunc func(event WebhookEvent) error
+
+// WebhookHandler is an http.Handler receiving Mandrill webhook posts. It
+// answers the HEAD request Mandrill checks new webhooks with, verifies the
+// signature of posts and calls the callbacks registered for each event.
+//
+// Callbacks must be registered before the handler serves requests.
+type WebhookHandler struct {
+	// AuthKey is the Webhook.AuthKey signatures are verified with, posts are
+	// refused when it is blank
+	AuthKey string
+	// SkipVerification accepts posts without checking their signature, for
+	// handlers behind another check or in tests
+	SkipVerification bool
+	// URL is the url the webhook was added with, which signatures are computed on
+	URL string
+	// Concurrency is the number of events handled at once, 0 means DefaultWebhookConcurrency
+	Concurrency int
+	// Store, when set, saves every verified batch before it is dispatched
+	Store EventStore
+
+	handlers map[string][]WebhookEventFunc
+	any      []WebhookEventFunc
+}
+
+// NewWebhookHandler returns a handler for the webhook added with url and
+// returning authKey.
+func NewWebhookHandler(authKey string, url string) *WebhookHandler {
+	return &WebhookHandler{AuthKey: authKey, URL: url}
+}
+
+// On registers fn for the events of a type, one of the WebhookEvent constants.
+func (h *WebhookHandler) On(event string, fn WebhookEventFunc) {
+	if h.handlers == nil {
+		h.handlers = make(map[string][]WebhookEventFunc)
+	}
+	h.handlers[event] = append(h.handlers[event], fn)
+}
+
+// OnAny registers fn for every event.
+func (h *WebhookHandler) OnAny(fn WebhookEventFunc) {
+	h.any = append(h.any, fn)
+}
+
+func (h *WebhookHandler) OnSend(fn WebhookEventFunc)       { h.On(WebhookEventSend, fn) }
+func (h *WebhookHandler) OnDeferral(fn WebhookEventFunc)   { h.On(WebhookEventDeferral, fn) }
+func (h *WebhookHandler) OnHardBounce(fn WebhookEventFunc) { h.On(WebhookEventHardBounce, fn) }
+func (h *WebhookHandler) OnSoftBounce(fn WebhookEventFunc) { h.On(WebhookEventSoftBounce, fn) }
+func (h *WebhookHandler) OnOpen(fn WebhookEventFunc)       { h.On(WebhookEventOpen, fn) }
+func (h *WebhookHandler) OnClick(fn WebhookEventFunc)      { h.On(WebhookEventClick, fn) }
+func (h *WebhookHandler) OnSpam(fn WebhookEventFunc)       { h.On(WebhookEventSpam, fn) }
+func (h *WebhookHandler) OnUnsub(fn WebhookEventFunc)      { h.On(WebhookEventUnsub, fn) }
+func (h *WebhookHandler) OnReject(fn WebhookEventFunc)     { h.On(WebhookEventReject, fn) }
+func (h *WebhookHandler) OnInbound(fn WebhookEventFunc)    { h.On(WebhookEventInbound, fn) }
+func (h *WebhookHandler) OnWhitelist(fn WebhookEventFunc)  { h.On(WebhookEventWhitelist, fn) }
+func (h *WebhookHandler) OnBlacklist(fn WebhookEventFunc)  { h.On(WebhookEventBlacklist, fn) }
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "HEAD":
+		// Mandrill checks the url answers when the webhook is added
+		w.WriteHeader(http.StatusOK)
+		return
+	case "POST":
+	default:
+		w.Header().Set("Allow", "HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	authKey := h.AuthKey
+	switch {
+	case h.SkipVerification:
+		authKey = ""
+	case authKey == "":
+		// a handler missing its key must not take posts from anyone
+		http.Error(w, "WebhookHandler has no AuthKey to verify signatures with", http.StatusForbidden)
+		return
+	case h.URL == "":
+		http.Error(w, "WebhookHandler has no URL to verify signatures with", http.StatusInternalServerError)
+		return
+	}
+	events, err := ParseWebhookRequest(r, authKey, h.URL)
+	switch {
+	case err == ErrInvalidSignature:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := storeBatch(h.Store, h.URL, r.PostForm.Get("mandrill_events")); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.Dispatch(events); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Dispatch calls the callbacks registered for each event, at most
+// Concurrency events at once, so in no particular order. It returns an error
+// when a callback fails or panics.
+func (h *WebhookHandler) Dispatch(events []WebhookEvent) error {
+	concurrency := h.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultWebhookConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var first error
+	failed := 0
+	for _, event := range events {
+		fns := append(append([]WebhookEventFunc(nil), h.handlers[event.Event]...), h.any...)
+		if len(fns) == 0 {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(event WebhookEvent) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := callWebhookFuncs(fns, event); err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				failed++
+				mu.Unlock()
+			}
+		}(event)
+	}
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d events failed: %v", failed, len(events), first)
+	}
+	return nil
+}
+
+func callWebhookFuncs(fns []WebhookEventFunc, event WebhookEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s event %s: panic: %v", event.Event, event.Id, r)
+		}
+	}()
+	for _, fn := range fns {
+		if err := fn(event); err != nil {
+			return fmt.Errorf("%s event %s: %v", event.Event, event.Id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func postWebhook(h http.Handler, authKey string, webhookURL string, batch string) *httptest.ResponseRecorder {
+	form := url.Values{"mandrill_events": {batch}}
+	r := httptest.NewRequest("POST", webhookURL, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(MandrillSignatureHeader, WebhookSignature(authKey, webhookURL, form))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestWebhookHandlerDispatch(t *testing.T) {
+	const webhookURL = "https://example.com/mandrill"
+	h := NewWebhookHandler("secret", webhookURL)
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	count := func(event WebhookEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[event.Event]++
+		return nil
+	}
+	h.OnClick(count)
+	h.OnHardBounce(count)
+	h.OnBlacklist(count)
+	var all int32
+	h.OnAny(func(event WebhookEvent) error {
+		atomic.AddInt32(&all, 1)
+		return nil
+	})
+
+	if w := postWebhook(h, "secret", webhookURL, webhookBatch); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if seen[WebhookEventClick] != 1 || seen[WebhookEventHardBounce] != 1 || seen[WebhookEventBlacklist] != 1 || all != 4 {
+		t.Errorf("wrong dispatch %v, %d events in all", seen, all)
+	}
+	if w := postWebhook(h, "wrong", webhookURL, webhookBatch); w.Code != http.StatusForbidden {
+		t.Errorf("bad signature got status %d", w.Code)
+	}
+	if w := postWebhook(h, "secret", webhookURL, "[]"); w.Code != http.StatusOK {
+		t.Errorf("empty batch got status %d", w.Code)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("HEAD", webhookURL, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("HEAD got status %d", w.Code)
+	}
+}
+
+func TestWebhookHandlerErrors(t *testing.T) {
+	h := &WebhookHandler{Concurrency: 2, SkipVerification: true}
+	var running, peak int32
+	h.OnAny(func(event WebhookEvent) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		switch event.Event {
+		case WebhookEventHardBounce:
+			return errors.New("database down")
+		case WebhookEventInbound:
+			panic("boom")
+		}
+		return nil
+	})
+	w := postWebhook(h, "", "https://example.com/mandrill", webhookBatch)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "2 of 4 events failed") {
+		t.Errorf("got status %d: %s", w.Code, w.Body)
+	}
+	if peak > 2 {
+		t.Errorf("%d events handled at once, concurrency is 2", peak)
+	}
+}
+
+func TestWebhookHandlerWithoutAuthKey(t *testing.T) {
+	const webhookURL = "https://example.com/mandrill"
+	var dispatched int32
+	h := NewWebhookHandler("", webhookURL)
+	h.OnAny(func(event WebhookEvent) error {
+		atomic.AddInt32(&dispatched, 1)
+		return nil
+	})
+	if w := postWebhook(h, "", webhookURL, webhookBatch); w.Code != http.StatusForbidden || dispatched != 0 {
+		t.Errorf("a handler without key accepted a post, got status %d", w.Code)
+	}
+	h.SkipVerification = true
+	if w := postWebhook(h, "anything", webhookURL, webhookBatch); w.Code != http.StatusOK || dispatched != 4 {
+		t.Errorf("got status %d: %s", w.Code, w.Body)
+	}
+}
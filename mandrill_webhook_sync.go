@@ -0,0 +1,176 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the operations a WebhookSyncAction can perform
+const (
+	WebhookSyncCreate = "create"
+	WebhookSyncUpdate = "update"
+	WebhookSyncDelete = "delete"
+)
+
+// WebhookSpec is a Mandrill webhook as it should be, the url identifies it.
+type WebhookSpec struct {
+	Url         string
+	Description string
+	Events      []string
+}
+
+// WebhookSyncOptions controls how webhooks are reconciled by EnsureWebhooks.
+type WebhookSyncOptions struct {
+	// DryRun only computes the plan, nothing is changed
+	DryRun bool
+	// DeleteOrphans deletes the webhooks whose url is not wanted. Extra
+	// webhooks with a wanted url are always deleted.
+	DeleteOrphans bool
+}
+
+// WebhookSyncAction is one step of a WebhookSyncPlan.
+type WebhookSyncAction struct {
+	Op string
+	// ListId is the MailChimp list of the webhook, blank for Mandrill
+	ListId string
+	// Id is the Mandrill webhook updated or deleted
+	Id  int
+	Url string
+	// Changes lists the fields that differ, "events" or "actions" for example
+	Changes []string
+	// Mandrill and Chimp are the wanted webhook of creates and updates
+	Mandrill *WebhookSpec  `json:"-"`
+	Chimp    *ChimpWebhook `json:"-"`
+}
+
+func (a WebhookSyncAction) String() string {
+	s := fmt.Sprintf("%s %s", a.Op, a.Url)
+	if a.ListId != "" {
+		s = fmt.Sprintf("%s on list %s", s, a.ListId)
+	}
+	if len(a.Changes) > 0 {
+		s = fmt.Sprintf("%s (%s)", s, strings.Join(a.Changes, ", "))
+	}
+	return s
+}
+
+// WebhookSyncPlan is the list of changes needed to bring the webhooks in line
+// with the wanted ones.
+type WebhookSyncPlan struct {
+	Actions   []WebhookSyncAction
+	Unchanged []string
+}
+
+// Empty reports whether the webhooks are already as wanted.
+func (p WebhookSyncPlan) Empty() bool {
+	return len(p.Actions) == 0
+}
+
+func (p WebhookSyncPlan) String() string {
+	var buf bytes.Buffer
+	for _, action := range p.Actions {
+		fmt.Fprintln(&buf, action)
+	}
+	fmt.Fprintf(&buf, "%d to change, %d unchanged\n", len(p.Actions), len(p.Unchanged))
+	return buf.String()
+}
+
+// PlanWebhookSync compares the wanted webhooks to the ones returned by
+// WebhooksList. A webhook whose events or description differ is updated in place.
+func PlanWebhookSync(wanted []WebhookSpec, remote []Webhook, opts WebhookSyncOptions) WebhookSyncPlan {
+	var plan WebhookSyncPlan
+	byUrl := make(map[string]Webhook, len(remote))
+	for _, webhook := range remote {
+		if _, found := byUrl[webhook.Url]; !found {
+			byUrl[webhook.Url] = webhook
+		}
+	}
+	kept := make(map[int]bool)
+	for i := range wanted {
+		w := wanted[i]
+		r, found := byUrl[w.Url]
+		if !found {
+			plan.Actions = append(plan.Actions, WebhookSyncAction{Op: WebhookSyncCreate, Url: w.Url, Mandrill: &w})
+			continue
+		}
+		kept[r.Id] = true
+		var changes []string
+		if !sameStrings(w.Events, r.Events) {
+			changes = append(changes, "events")
+		}
+		if w.Description != r.Description {
+			changes = append(changes, "description")
+		}
+		if len(changes) > 0 {
+			plan.Actions = append(plan.Actions, WebhookSyncAction{Op: WebhookSyncUpdate, Id: r.Id, Url: w.Url, Changes: changes, Mandrill: &w})
+			continue
+		}
+		plan.Unchanged = append(plan.Unchanged, w.Url)
+	}
+	wantedUrls := make(map[string]bool, len(wanted))
+	for _, w := range wanted {
+		wantedUrls[w.Url] = true
+	}
+	var deletes []Webhook
+	for _, r := range remote {
+		if kept[r.Id] || (!wantedUrls[r.Url] && !opts.DeleteOrphans) {
+			continue
+		}
+		deletes = append(deletes, r)
+	}
+	sort.SliceStable(deletes, func(i, j int) bool { return deletes[i].Url < deletes[j].Url })
+	for _, r := range deletes {
+		plan.Actions = append(plan.Actions, WebhookSyncAction{Op: WebhookSyncDelete, Id: r.Id, Url: r.Url})
+	}
+	return plan
+}
+
+// EnsureWebhooks brings the webhooks of the account in line with the wanted
+// ones. The plan is returned even when it was only partly applied, so
+// callers can tell how far it got.
+func (a *MandrillAPI) EnsureWebhooks(wanted []WebhookSpec, opts WebhookSyncOptions) (WebhookSyncPlan, error) {
+	remote, err := a.WebhooksList()
+	if err != nil {
+		return WebhookSyncPlan{}, err
+	}
+	plan := PlanWebhookSync(wanted, remote, opts)
+	if opts.DryRun {
+		return plan, nil
+	}
+	return plan, a.WebhookSyncApply(plan)
+}
+
+// WebhookSyncApply performs every action of a plan in order, stopping at the
+// first error.
+func (a *MandrillAPI) WebhookSyncApply(plan WebhookSyncPlan) error {
+	for _, action := range plan.Actions {
+		var err error
+		switch action.Op {
+		case WebhookSyncCreate:
+			_, err = a.WebhookAddWithDescription(action.Url, action.Mandrill.Description, action.Mandrill.Events)
+		case WebhookSyncUpdate:
+			_, err = a.WebhookUpdateById(action.Id, action.Url, action.Mandrill.Description, action.Mandrill.Events)
+		case WebhookSyncDelete:
+			_, err = a.WebhookDelete(action.Id)
+		default:
+			err = fmt.Errorf("unknown webhook sync operation %q", action.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", action, err)
+		}
+	}
+	return nil
+}
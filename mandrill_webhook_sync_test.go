@@ -0,0 +1,103 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeWebhookServer answers the webhook endpoints the way Mandrill does,
+// keeping the webhooks in memory.
+func fakeWebhookServer(webhooks []Webhook) (*MandrillAPI, func()) {
+	nextId := 100
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			Id          int      `json:"id"`
+			Url         string   `json:"url"`
+			Description string   `json:"description"`
+			Events      []string `json:"events"`
+		}
+		json.NewDecoder(r.Body).Decode(&params)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/webhooks/list.json"):
+			json.NewEncoder(w).Encode(webhooks)
+		case strings.HasSuffix(r.URL.Path, "/webhooks/add.json"):
+			nextId++
+			webhook := Webhook{Id: nextId, Url: params.Url, Description: params.Description, Events: params.Events}
+			webhooks = append(webhooks, webhook)
+			json.NewEncoder(w).Encode(webhook)
+		default:
+			for i, webhook := range webhooks {
+				if webhook.Id != params.Id {
+					continue
+				}
+				if strings.HasSuffix(r.URL.Path, "/webhooks/delete.json") {
+					webhooks = append(webhooks[:i], webhooks[i+1:]...)
+				} else {
+					webhook.Url, webhook.Description, webhook.Events = params.Url, params.Description, params.Events
+					webhooks[i] = webhook
+				}
+				json.NewEncoder(w).Encode(webhook)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"status":"error","code":3,"name":"Unknown_Webhook","message":"no webhook"}`))
+		}
+	}))
+	return &MandrillAPI{endpoint: srv.URL}, srv.Close
+}
+
+func TestEnsureWebhooks(t *testing.T) {
+	api, closer := fakeWebhookServer([]Webhook{
+		{Id: 1, Url: "https://example.com/events", Events: []string{"send"}},
+		{Id: 2, Url: "https://example.com/old"},
+		{Id: 3, Url: "https://example.com/sync", Description: "sync", Events: []string{"whitelist", "blacklist"}},
+		{Id: 4, Url: "https://example.com/events", Events: []string{"send"}},
+	})
+	defer closer()
+	wanted := []WebhookSpec{
+		{Url: "https://example.com/events", Description: "events", Events: []string{"send", "hard_bounce"}},
+		{Url: "https://example.com/sync", Description: "sync", Events: []string{"blacklist", "whitelist"}},
+		{Url: "https://example.com/new", Events: []string{"open"}},
+	}
+	plan, err := api.EnsureWebhooks(wanted, WebhookSyncOptions{DryRun: true, DeleteOrphans: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "update https://example.com/events (events, description)\n" +
+		"create https://example.com/new\n" +
+		"delete https://example.com/events\n" +
+		"delete https://example.com/old\n" +
+		"4 to change, 1 unchanged\n"
+	if plan.String() != expected {
+		t.Errorf("wrong plan\n%s", plan)
+	}
+	if plan.Actions[0].Id != 1 || plan.Actions[2].Id != 4 {
+		t.Errorf("wrong ids %+v", plan.Actions)
+	}
+
+	if _, err := api.EnsureWebhooks(wanted, WebhookSyncOptions{DeleteOrphans: true}); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = api.EnsureWebhooks(wanted, WebhookSyncOptions{DeleteOrphans: true})
+	if err != nil || !plan.Empty() || len(plan.Unchanged) != 3 {
+		t.Errorf("not converged %v\n%s", err, plan)
+	}
+	webhook, err := api.WebhookUpdate("https://example.com/new", []string{"open", "click"})
+	if err != nil || webhook.Id != 101 || len(webhook.Events) != 2 {
+		t.Errorf("wrong update %+v %v", webhook, err)
+	}
+}
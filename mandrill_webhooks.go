@@ -11,7 +11,10 @@
 
 package gochimp
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // see https://mandrillapp.com/api/docs/webhooks.html
 const webhooks_list_endpoint string = "/webhooks/list.json"     //Get the list of all webhooks defined on the account
@@ -29,11 +32,17 @@ func (a *MandrillAPI) WebhooksList() (response []Webhook, err error) {
 
 // can error with one of the following: Invalid_Key, ValidationError, GeneralError
 func (a *MandrillAPI) WebhookAdd(url string, events []string) (Webhook, error) {
+	return a.WebhookAddWithDescription(url, "", events)
+}
+
+// can error with one of the following: Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) WebhookAddWithDescription(url string, description string, events []string) (Webhook, error) {
 	if url == "" {
 		return Webhook{}, errors.New("url cannot be blank")
 	}
 	var params map[string]interface{} = make(map[string]interface{})
 	params["url"] = url
+	params["description"] = description
 	params["events"] = events
 	return getWebhook(a, params, webhooks_add_endpoint)
 }
@@ -48,13 +57,38 @@ func (a *MandrillAPI) WebhookInfo(id int) (Webhook, error) {
 	return getWebhook(a, params, webhooks_info_endpoint)
 }
 
+// WebhookUpdate sets the events of the webhook added with url, which it looks
+// up with WebhooksList since Mandrill updates webhooks by id. Its description
+// is kept.
 // can error with one of the following: Unknown_Webhook, Invalid_Key, ValidationError, GeneralError
 func (a *MandrillAPI) WebhookUpdate(url string, events []string) (Webhook, error) {
+	if url == "" {
+		return Webhook{}, errors.New("url cannot be blank")
+	}
+	webhooks, err := a.WebhooksList()
+	if err != nil {
+		return Webhook{}, err
+	}
+	for _, webhook := range webhooks {
+		if webhook.Url == url {
+			return a.WebhookUpdateById(webhook.Id, url, webhook.Description, events)
+		}
+	}
+	return Webhook{}, fmt.Errorf("no webhook with url %s", url)
+}
+
+// can error with one of the following: Unknown_Webhook, Invalid_Key, ValidationError, GeneralError
+func (a *MandrillAPI) WebhookUpdateById(id int, url string, description string, events []string) (Webhook, error) {
+	if id <= 0 {
+		return Webhook{}, errors.New("id must be > 0")
+	}
 	if url == "" {
 		return Webhook{}, errors.New("url cannot be blank")
 	}
 	var params map[string]interface{} = make(map[string]interface{})
+	params["id"] = id
 	params["url"] = url
+	params["description"] = description
 	params["events"] = events
 	return getWebhook(a, params, webhooks_update_endpoint)
 }
@@ -78,6 +112,7 @@ func getWebhook(a *MandrillAPI, params map[string]interface{}, endpoint string)
 type Webhook struct {
 	Id          int      `json:"id"`
 	Url         string   `json:"url"`
+	Description string   `json:"description"`
 	AuthKey     string   `json:"auth_key"`
 	Events      []string `json:"events"`
 	CreatedAt   APITime  `json:"created_at"`
@@ -0,0 +1,633 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MarkdownMainRegion is the mc:edit region Compose fills.
+const MarkdownMainRegion = "main"
+
+// DefaultMarkdownLayout is a plain single column email layout with a "main"
+// mc:edit region.
+const DefaultMarkdownLayout = `<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>*|MC:SUBJECT|*</title>
+<style type="text/css">
+body { margin: 0; padding: 0; background-color: #f4f4f4; }
+.container { width: 100%; max-width: 600px; background-color: #ffffff; }
+.content { padding: 24px; font-family: Helvetica, Arial, sans-serif; font-size: 16px; line-height: 1.5; color: #333333; }
+.content a { color: #1a73e8; }
+</style>
+</head>
+<body>
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0"><tr><td align="center">
+<table role="presentation" class="container" cellpadding="0" cellspacing="0" border="0"><tr>
+<td class="content" mc:edit="main"></td>
+</tr></table>
+</td></tr></table>
+</body>
+</html>`
+
+// MarkdownComposer turns Markdown copy into the Html and Text of a Message.
+// The rendered Markdown goes into the mc:edit regions of a layout, so the same
+// layout can also be stored as a Mandrill template and filled with
+// TemplateContent.
+type MarkdownComposer struct {
+	// Layout is the html the Markdown is placed in, each region replaces the
+	// content of the element with the matching mc:edit attribute. Blank means
+	// DefaultMarkdownLayout.
+	Layout string
+	// CSSInliner, when set, is applied to the composed html, InlineCSS for example
+	CSSInliner func(html string) (string, error)
+	// TextOptions are used to generate the Text of the message with HtmlToText
+	TextOptions HtmlToTextOptions
+}
+
+// NewMarkdownComposer returns a composer using layout, blank for DefaultMarkdownLayout.
+func NewMarkdownComposer(layout string) *MarkdownComposer {
+	return &MarkdownComposer{Layout: layout}
+}
+
+// Compose renders markdown into the "main" region of the layout and fills
+// the Html and Text of message.
+func (c *MarkdownComposer) Compose(message *Message, markdown string) error {
+	return c.ComposeRegions(message, map[string]string{MarkdownMainRegion: markdown})
+}
+
+// ComposeRegions renders the Markdown of each region, keyed by mc:edit name,
+// into the layout and fills the Html and Text of message.
+func (c *MarkdownComposer) ComposeRegions(message *Message, regions map[string]string) error {
+	layout := c.Layout
+	if layout == "" {
+		layout = DefaultMarkdownLayout
+	}
+	content := make(map[string]string, len(regions))
+	for name, markdown := range regions {
+		content[name] = MarkdownToHtml(markdown)
+	}
+	body, err := fillEditRegions(layout, content)
+	if err != nil {
+		return err
+	}
+	text, err := HtmlToText(body, c.TextOptions)
+	if err != nil {
+		return err
+	}
+	if c.CSSInliner != nil {
+		if body, err = c.CSSInliner(body); err != nil {
+			return err
+		}
+	}
+	message.Html = body
+	message.Text = text
+	return nil
+}
+
+// TemplateContent renders the Markdown of each region, keyed by mc:edit name,
+// into the template content of MessageSendTemplate.
+func (c *MarkdownComposer) TemplateContent(regions map[string]string) []Var {
+	names := make([]string, 0, len(regions))
+	for name := range regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	content := make([]Var, len(names))
+	for i, name := range names {
+		content[i] = Var{Name: name, Content: MarkdownToHtml(regions[name])}
+	}
+	return content
+}
+
+// fillEditRegions replaces the content of the mc:edit elements of layout.
+func fillEditRegions(layout string, content map[string]string) (string, error) {
+	doc := parseHTML(layout)
+	var edits []htmlEdit
+	found := make(map[string]bool)
+	var err error
+	doc.root.walk(func(n *htmlNode) {
+		if n.kind != htmlElementNode || err != nil {
+			return
+		}
+		name, ok := n.attr("mc:edit")
+		if !ok {
+			return
+		}
+		body, ok := content[name]
+		if !ok {
+			return
+		}
+		if n.closeStart < 0 {
+			err = fmt.Errorf("mc:edit region %q is not closed", name)
+			return
+		}
+		found[name] = true
+		edits = append(edits, htmlEdit{n.end, n.closeStart, body})
+	})
+	if err != nil {
+		return "", err
+	}
+	for name := range content {
+		if !found[name] {
+			return "", fmt.Errorf("layout has no mc:edit region %q", name)
+		}
+	}
+	return applyHTMLEdits(layout, edits), nil
+}
+
+// merge tags are swapped for placeholders while the Markdown is parsed, so
+// that they come out exactly as written
+const (
+	markdownPlaceholderStart = "\ue000"
+	markdownPlaceholderEnd   = "\ue001"
+)
+
+var markdownPlaceholder = regexp.MustCompile(markdownPlaceholderStart + `([0-9]+)` + markdownPlaceholderEnd)
+
+// MarkdownToHtml converts Markdown to html. It supports the usual Markdown:
+// headings, paragraphs, emphasis, code, links, images, lists, block quotes,
+// rules and raw html. MailChimp *|TAGS|* and handlebars {{tags}} are copied
+// to the html unescaped, a conditional tag alone on its line is kept out of
+// paragraphs.
+func MarkdownToHtml(markdown string) string {
+	var tags []string
+	protect := func(tag string) string {
+		tags = append(tags, tag)
+		return markdownPlaceholderStart + strconv.Itoa(len(tags)-1) + markdownPlaceholderEnd
+	}
+	markdown = mailchimpMergeTag.ReplaceAllStringFunc(markdown, protect)
+	markdown = handlebarsMergeTag.ReplaceAllStringFunc(markdown, protect)
+	markdown = strings.Replace(strings.Replace(markdown, "\r\n", "\n", -1), "\t", "    ", -1)
+
+	var out strings.Builder
+	renderMarkdownBlocks(&out, strings.Split(markdown, "\n"), false, tags)
+	return markdownPlaceholder.ReplaceAllStringFunc(out.String(), func(s string) string {
+		i, _ := strconv.Atoi(markdownPlaceholder.FindStringSubmatch(s)[1])
+		return tags[i]
+	})
+}
+
+var (
+	markdownHeading  = regexp.MustCompile(`^(#{1,6})(?:[ ]+(.*?))?(?:[ ]+#+)?[ ]*$`)
+	markdownRule     = regexp.MustCompile(`^(?:(?:\*[ ]*){3,}|(?:-[ ]*){3,}|(?:_[ ]*){3,})$`)
+	markdownListItem = regexp.MustCompile(`^( {0,3})([-*+]|[0-9]{1,9}[.)])( +|$)`)
+	markdownFence    = regexp.MustCompile("^(`{3,}|~{3,})[ ]*([^`]*)$")
+	markdownHTMLTag  = regexp.MustCompile(`^<(?:!--|/?([a-zA-Z][a-zA-Z0-9]*))`)
+)
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func blankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// renderMarkdownBlocks renders lines as blocks. The paragraphs of tight list
+// items are not wrapped in <p>.
+func renderMarkdownBlocks(out *strings.Builder, lines []string, tight bool, tags []string) {
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		if tight {
+			out.WriteString(renderMarkdownParagraph(paragraph))
+		} else {
+			out.WriteString("<p>" + renderMarkdownParagraph(paragraph) + "</p>\n")
+		}
+		paragraph = nil
+	}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+			i++
+		case markdownFence.MatchString(trimmed):
+			flush()
+			m := markdownFence.FindStringSubmatch(trimmed)
+			i++
+			var code []string
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]) {
+				code = append(code, lines[i])
+				i++
+			}
+			i++
+			class := ""
+			if lang := strings.Fields(m[2]); len(lang) > 0 {
+				class = ` class="language-` + htmlAttrEscaper.Replace(lang[0]) + `"`
+			}
+			out.WriteString("<pre><code" + class + ">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+		case markdownHeading.MatchString(trimmed):
+			flush()
+			m := markdownHeading.FindStringSubmatch(trimmed)
+			level := strconv.Itoa(len(m[1]))
+			out.WriteString("<h" + level + ">" + renderMarkdownInline(m[2]) + "</h" + level + ">\n")
+			i++
+		case markdownRule.MatchString(trimmed):
+			flush()
+			out.WriteString("<hr>\n")
+			i++
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quoted []string
+			for i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">") {
+				l := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(l, " "))
+				i++
+			}
+			out.WriteString("<blockquote>\n")
+			renderMarkdownBlocks(out, quoted, false, tags)
+			out.WriteString("</blockquote>\n")
+		case markdownListItem.MatchString(line) && (len(paragraph) == 0 || !markdownRule.MatchString(trimmed)):
+			flush()
+			i = renderMarkdownList(out, lines, i, tags)
+		case conditionalPlaceholder(trimmed, tags):
+			flush()
+			out.WriteString(trimmed + "\n")
+			i++
+		case len(paragraph) == 0 && markdownHTMLBlock(trimmed):
+			for i < len(lines) && !blankLine(lines[i]) {
+				out.WriteString(lines[i] + "\n")
+				i++
+			}
+		default:
+			paragraph = append(paragraph, strings.TrimLeft(line, " "))
+			i++
+		}
+	}
+	flush()
+}
+
+// conditionalPlaceholder reports whether line is a single merge tag opening
+// or closing a conditional block, *|IF:NAME|* or {{#if name}} for example.
+func conditionalPlaceholder(line string, tags []string) bool {
+	line = strings.TrimSpace(line)
+	loc := markdownPlaceholder.FindStringSubmatchIndex(line)
+	if loc == nil || loc[0] != 0 || loc[1] != len(line) {
+		return false
+	}
+	i, _ := strconv.Atoi(line[loc[2]:loc[3]])
+	tag := strings.ToUpper(tags[i])
+	if strings.HasPrefix(tag, "{{") {
+		expr := strings.TrimLeft(tag, "{ ")
+		return strings.HasPrefix(expr, "#") || strings.HasPrefix(expr, "/") || strings.HasPrefix(expr, "ELSE")
+	}
+	for _, prefix := range []string{"*|IF:", "*|IFNOT:", "*|ELSEIF:", "*|ELSE:", "*|END:IF"} {
+		if strings.HasPrefix(tag, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// markdownHTMLBlock reports whether line starts a block of raw html.
+func markdownHTMLBlock(line string) bool {
+	m := markdownHTMLTag.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	return m[1] == "" || htmlTextBlockElements[strings.ToLower(m[1])]
+}
+
+func renderMarkdownParagraph(lines []string) string {
+	var b strings.Builder
+	for i, line := range lines {
+		last := i == len(lines)-1
+		hardBreak := !last && (strings.HasSuffix(line, "  ") || strings.HasSuffix(line, `\`))
+		line = strings.TrimRight(line, " ")
+		if hardBreak {
+			line = strings.TrimSuffix(line, `\`)
+		}
+		b.WriteString(renderMarkdownInline(line))
+		switch {
+		case hardBreak:
+			b.WriteString("<br>\n")
+		case !last:
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// renderMarkdownList renders the list starting at lines[start] and returns
+// the index of the line after it.
+func renderMarkdownList(out *strings.Builder, lines []string, start int, tags []string) int {
+	first := markdownListItem.FindStringSubmatch(lines[start])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	delimiter := first[2][len(first[2])-1]
+	var items [][]string
+	loose := false
+	i := start
+	for i < len(lines) {
+		m := markdownListItem.FindStringSubmatch(lines[i])
+		if m == nil || m[2][len(m[2])-1] != delimiter {
+			break
+		}
+		contentIndent := len(m[0])
+		if m[3] == "" {
+			contentIndent++
+		}
+		item := []string{lines[i][len(m[0]):]}
+		i++
+		for i < len(lines) {
+			l := lines[i]
+			if blankLine(l) {
+				j := i
+				for j < len(lines) && blankLine(lines[j]) {
+					j++
+				}
+				if j < len(lines) && indentation(lines[j]) >= contentIndent {
+					for ; i < j; i++ {
+						item = append(item, "")
+					}
+					continue
+				}
+				break
+			}
+			if indentation(l) >= contentIndent {
+				item = append(item, l[contentIndent:])
+			} else if markdownListItem.MatchString(l) || markdownRule.MatchString(strings.TrimSpace(l)) {
+				break
+			} else if !blankLine(item[len(item)-1]) {
+				// a lazy continuation of the item's paragraph
+				item = append(item, strings.TrimSpace(l))
+			} else {
+				break
+			}
+			i++
+		}
+		for k := 1; k < len(item); k++ {
+			if blankLine(item[k]) && !blankLine(item[k-1]) && k+1 < len(item) && indentation(item[k+1]) == 0 && !markdownListItem.MatchString(item[k+1]) {
+				loose = true
+			}
+		}
+		items = append(items, item)
+		j := i
+		for j < len(lines) && blankLine(lines[j]) {
+			j++
+		}
+		if j > i && j < len(lines) {
+			if m := markdownListItem.FindStringSubmatch(lines[j]); m != nil && m[2][len(m[2])-1] == delimiter {
+				loose = true
+				i = j
+				continue
+			}
+		}
+		if j > i {
+			break
+		}
+	}
+	tag := "ul"
+	open := "<ul>"
+	if ordered {
+		tag = "ol"
+		open = "<ol>"
+		if number, _ := strconv.Atoi(strings.TrimRight(first[2], ".)")); number != 1 {
+			open = `<ol start="` + strconv.Itoa(number) + `">`
+		}
+	}
+	out.WriteString(open + "\n")
+	for _, item := range items {
+		var content strings.Builder
+		renderMarkdownBlocks(&content, item, !loose, tags)
+		out.WriteString("<li>" + strings.TrimSuffix(content.String(), "\n") + "</li>\n")
+	}
+	out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+const markdownPunctuation = "\\`*_{}[]()#+-.!|<>~\""
+
+var (
+	markdownEntity    = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+	markdownInlineTag = regexp.MustCompile(`^(?:</?[a-zA-Z][a-zA-Z0-9-]*(?:\s+[^<>]*)?/?>|<!--[\s\S]*?-->)`)
+	markdownAutolink  = regexp.MustCompile(`^<((?:https?://|mailto:)[^<>\s]+)>`)
+)
+
+// renderMarkdownInline renders the inline markup of s: emphasis, code,
+// links, images and raw tags.
+func renderMarkdownInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch c {
+		case '\\':
+			if i+1 < len(s) && strings.IndexByte(markdownPunctuation, s[i+1]) >= 0 {
+				b.WriteString(html.EscapeString(s[i+1 : i+2]))
+				i += 2
+				continue
+			}
+		case '`':
+			run := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			delim := s[i : i+run]
+			if end := strings.Index(s[i+run:], delim); end >= 0 {
+				code := s[i+run : i+run+end]
+				if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' {
+					code = code[1 : len(code)-1]
+				}
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += run + end + run
+				continue
+			}
+			b.WriteString(delim)
+			i += run
+			continue
+		case '!':
+			if text, dest, title, n, ok := markdownLink(s[i+1:]); ok {
+				b.WriteString(`<img src="` + htmlAttrEscaper.Replace(dest) + `" alt="` + htmlAttrEscaper.Replace(text) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + htmlAttrEscaper.Replace(title) + `"`)
+				}
+				b.WriteString(">")
+				i += 1 + n
+				continue
+			}
+		case '[':
+			if text, dest, title, n, ok := markdownLink(s[i:]); ok {
+				b.WriteString(`<a href="` + htmlAttrEscaper.Replace(dest) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + htmlAttrEscaper.Replace(title) + `"`)
+				}
+				b.WriteString(">" + renderMarkdownInline(text) + "</a>")
+				i += n
+				continue
+			}
+		case '<':
+			if m := markdownAutolink.FindStringSubmatch(s[i:]); m != nil {
+				b.WriteString(`<a href="` + htmlAttrEscaper.Replace(m[1]) + `">` + html.EscapeString(strings.TrimPrefix(m[1], "mailto:")) + "</a>")
+				i += len(m[0])
+				continue
+			}
+			if tag := markdownInlineTag.FindString(s[i:]); tag != "" {
+				b.WriteString(tag)
+				i += len(tag)
+				continue
+			}
+			b.WriteString("&lt;")
+			i++
+			continue
+		case '>':
+			b.WriteString("&gt;")
+			i++
+			continue
+		case '&':
+			if entity := markdownEntity.FindString(s[i:]); entity != "" {
+				b.WriteString(entity)
+				i += len(entity)
+				continue
+			}
+			b.WriteString("&amp;")
+			i++
+			continue
+		case '*', '_':
+			if inner, n, strong, ok := markdownEmphasis(s, i); ok {
+				tag := "em"
+				if strong {
+					tag = "strong"
+				}
+				b.WriteString("<" + tag + ">" + renderMarkdownInline(inner) + "</" + tag + ">")
+				i += n
+				continue
+			}
+			run := len(s[i:]) - len(strings.TrimLeft(s[i:], string(c)))
+			b.WriteString(s[i : i+run])
+			i += run
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+func isMarkdownWordByte(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// markdownEmphasis matches the emphasis opening at s[i] and returns its
+// content and the length of the whole span.
+func markdownEmphasis(s string, i int) (inner string, n int, strong bool, ok bool) {
+	c := s[i]
+	run := len(s[i:]) - len(strings.TrimLeft(s[i:], string(c)))
+	// underscores inside words, as in snake_case, are not emphasis
+	if c == '_' && i > 0 && isMarkdownWordByte(s[i-1]) {
+		return "", 0, false, false
+	}
+	for _, size := range []int{2, 1} {
+		if run < size {
+			continue
+		}
+		start := i + size
+		if start >= len(s) || s[start] == ' ' {
+			continue
+		}
+		delim := strings.Repeat(string(c), size)
+		for j := start + 1; j+size <= len(s); j++ {
+			if s[j:j+size] != delim || s[j-1] == ' ' {
+				continue
+			}
+			closeRun := len(s[j:]) - len(strings.TrimLeft(s[j:], string(c)))
+			// a single delimiter does not close on a double one, which is nested strong emphasis
+			if size == 1 && closeRun == 2 {
+				j++
+				continue
+			}
+			if c == '_' && j+size < len(s) && isMarkdownWordByte(s[j+size]) {
+				continue
+			}
+			return s[start:j], j + size - i, size == 2, true
+		}
+	}
+	return "", 0, false, false
+}
+
+// markdownLink parses a link starting with '[' at s[0]: [text](destination "title").
+func markdownLink(s string) (text string, dest string, title string, n int, ok bool) {
+	if s == "" || s[0] != '[' {
+		return
+	}
+	depth := 0
+	end := -1
+	for j := 0; j < len(s) && end < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				end = j
+			}
+		}
+	}
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return
+	}
+	text = s[1:end]
+	j := end + 2
+	for j < len(s) && s[j] == ' ' {
+		j++
+	}
+	if j < len(s) && s[j] == '<' {
+		close := strings.IndexByte(s[j:], '>')
+		if close < 0 {
+			return
+		}
+		dest = s[j+1 : j+close]
+		j += close + 1
+	} else {
+		parens := 0
+		destStart := j
+		for ; j < len(s) && s[j] != ' '; j++ {
+			if s[j] == '(' {
+				parens++
+			} else if s[j] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+		}
+		dest = s[destStart:j]
+	}
+	for j < len(s) && s[j] == ' ' {
+		j++
+	}
+	if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+		close := strings.IndexByte(s[j+1:], s[j])
+		if close < 0 {
+			return
+		}
+		title = s[j+1 : j+1+close]
+		j += close + 2
+		for j < len(s) && s[j] == ' ' {
+			j++
+		}
+	}
+	if j >= len(s) || s[j] != ')' {
+		return
+	}
+	return text, dest, title, j + 1, true
+}
@@ -0,0 +1,111 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdownToHtml(t *testing.T) {
+	markdown := `# Hi *|FNAME|*
+
+Your **order** of *2 items* ships today, see [tracking](https://example.com/t?id=*|ORDER_ID|*&src=mail "Track").
+Use code ` + "`A<B`" + ` & save_the_date.
+
+*|IF:COUPON|*
+Your coupon: {{{coupon_html}}}
+*|END:IF|*
+
+- one
+- two
+  1. nested
+- three
+
+> quoted
+
+---
+
+<div class="footer">raw *html*</div>
+
+![logo](https://example.com/logo.png)`
+	want := `<h1>Hi *|FNAME|*</h1>
+<p>Your <strong>order</strong> of <em>2 items</em> ships today, see <a href="https://example.com/t?id=*|ORDER_ID|*&amp;src=mail" title="Track">tracking</a>.
+Use code <code>A&lt;B</code> &amp; save_the_date.</p>
+*|IF:COUPON|*
+<p>Your coupon: {{{coupon_html}}}</p>
+*|END:IF|*
+<ul>
+<li>one</li>
+<li>two<ol>
+<li>nested</li>
+</ol></li>
+<li>three</li>
+</ul>
+<blockquote>
+<p>quoted</p>
+</blockquote>
+<hr>
+<div class="footer">raw *html*</div>
+<p><img src="https://example.com/logo.png" alt="logo"></p>
+`
+	if got := MarkdownToHtml(markdown); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMarkdownToHtmlLooseList(t *testing.T) {
+	got := MarkdownToHtml("1. first\n\n2. second\n   continued\n")
+	want := "<ol>\n<li><p>first</p></li>\n<li><p>second\ncontinued</p></li>\n</ol>\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMarkdownComposer(t *testing.T) {
+	composer := NewMarkdownComposer(`<html><body><div mc:edit="header">Default</div><div mc:edit="main"></div></body></html>`)
+	var message Message
+	err := composer.ComposeRegions(&message, map[string]string{"header": "**News**", "main": "Hello *|FNAME|*, [unsubscribe](*|UNSUB|*)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHtml := `<html><body><div mc:edit="header"><p><strong>News</strong></p>
+</div><div mc:edit="main"><p>Hello *|FNAME|*, <a href="*|UNSUB|*">unsubscribe</a></p>
+</div></body></html>`
+	if message.Html != wantHtml {
+		t.Errorf("got html\n%s", message.Html)
+	}
+	if !strings.HasPrefix(message.Text, "News\n\nHello *|FNAME|*, unsubscribe [1]") {
+		t.Errorf("got text\n%s", message.Text)
+	}
+	if err := composer.Compose(&message, "x"); err != nil {
+		t.Error(err)
+	}
+	if err := composer.ComposeRegions(&message, map[string]string{"sidebar": "x"}); err == nil {
+		t.Error("expected an error for a region missing from the layout")
+	}
+	content := composer.TemplateContent(map[string]string{"main": "*hi*"})
+	if len(content) != 1 || content[0].Name != "main" || content[0].Content != "<p><em>hi</em></p>\n" {
+		t.Errorf("wrong template content %+v", content)
+	}
+}
+
+func TestMarkdownComposerDefaultLayout(t *testing.T) {
+	var message Message
+	composer := &MarkdownComposer{CSSInliner: CSSInlineOptions{StripStyles: true}.Inline}
+	if err := composer.Compose(&message, "Hello"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(message.Html, `mc:edit="main" style="padding: 24px;`) || message.Text != "Hello" {
+		t.Errorf("got html\n%s\ntext %q", message.Html, message.Text)
+	}
+}
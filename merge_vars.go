@@ -0,0 +1,422 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gochimp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMergeVarTimeLayout is used for time.Time fields without a time= option.
+// It is the format MailChimp expects for date merge fields.
+const DefaultMergeVarTimeLayout = "2006-01-02"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// mergeVarField is a parsed `mergevar:"NAME,omitempty,time=layout"` tag. The
+// time layout runs to the end of the tag, so it may contain commas.
+type mergeVarField struct {
+	name      string
+	omitEmpty bool
+	layout    string
+}
+
+func parseMergeVarTag(tag string) mergeVarField {
+	f := mergeVarField{layout: DefaultMergeVarTimeLayout}
+	if i := strings.Index(tag, ",time="); i >= 0 {
+		f.layout = tag[i+len(",time="):]
+		tag = tag[:i]
+	}
+	parts := strings.Split(tag, ",")
+	f.name = parts[0]
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			f.omitEmpty = true
+		}
+	}
+	return f
+}
+
+// EncodeMergeVars turns a struct into merge vars for Message.GlobalMergeVars.
+// Fields are encoded when they carry a `mergevar:"NAME"` tag, and untagged
+// struct fields are flattened into their parent. The tag options are:
+//
+//	omitempty        leave the var out when the field has its zero value
+//	time=layout      the time.Format layout for time.Time fields, 2006-01-02 by default
+//
+// Tagged nested structs become objects and slices become arrays, which the
+// handlebars merge language can walk with {{#each}}.
+func EncodeMergeVars(v interface{}) ([]Var, error) {
+	var vars []Var
+	err := walkMergeVars(v, func(name string, content interface{}) {
+		vars = append(vars, Var{Name: name, Content: content})
+	})
+	return vars, err
+}
+
+// EncodeRecipientMergeVars is EncodeMergeVars for a single recipient, for Message.MergeVars.
+func EncodeRecipientMergeVars(recipient string, v interface{}) (MergeVars, error) {
+	vars, err := EncodeMergeVars(v)
+	return MergeVars{Recipient: recipient, Vars: vars}, err
+}
+
+// EncodeChimpMergeVars turns a struct into the merge_vars map of
+// ListsSubscribe, ListsMember and UpdateMember, following the same tags as
+// EncodeMergeVars.
+func EncodeChimpMergeVars(v interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	err := walkMergeVars(v, func(name string, content interface{}) {
+		vars[name] = content
+	})
+	return vars, err
+}
+
+// AddGlobalMergeVarsFrom encodes v with EncodeMergeVars and adds the result to the global merge vars.
+func (m *Message) AddGlobalMergeVarsFrom(v interface{}) error {
+	vars, err := EncodeMergeVars(v)
+	if err != nil {
+		return err
+	}
+	m.AddGlobalMergeVar(vars...)
+	return nil
+}
+
+// AddMergeVarsFrom encodes v with EncodeRecipientMergeVars and adds the result to the merge vars.
+func (m *Message) AddMergeVarsFrom(recipient string, v interface{}) error {
+	vars, err := EncodeRecipientMergeVars(recipient, v)
+	if err != nil {
+		return err
+	}
+	m.AddMergeVar(vars)
+	return nil
+}
+
+func walkMergeVars(v interface{}, emit func(name string, content interface{})) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return errors.New("merge vars cannot be encoded from nil")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("merge vars can only be encoded from a struct, not %s", rv.Type())
+	}
+	return encodeMergeVarStruct(rv, emit)
+}
+
+func encodeMergeVarStruct(rv reflect.Value, emit func(name string, content interface{})) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		fv := rv.Field(i)
+		tag, tagged := sf.Tag.Lookup("mergevar")
+		// the exported fields of embedded unexported structs are still encoded
+		if tag == "-" || (sf.PkgPath != "" && (!sf.Anonymous || tagged)) {
+			continue
+		}
+		if !tagged {
+			// untagged structs are flattened into their parent
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+				if err := encodeMergeVarStruct(fv, emit); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		field := parseMergeVarTag(tag)
+		if field.name == "" {
+			return fmt.Errorf("field %s has a mergevar tag without a name", sf.Name)
+		}
+		if field.omitEmpty && isEmptyMergeVar(fv) {
+			continue
+		}
+		content, err := encodeMergeVarValue(fv, field)
+		if err != nil {
+			return fmt.Errorf("%s: %v", field.name, err)
+		}
+		emit(field.name, content)
+	}
+	return nil
+}
+
+func encodeMergeVarValue(fv reflect.Value, field mergeVarField) (interface{}, error) {
+	for fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface {
+		if fv.IsNil() {
+			return nil, nil
+		}
+		fv = fv.Elem()
+	}
+	if fv.Type() == timeType {
+		t := fv.Interface().(time.Time)
+		if t.IsZero() {
+			return "", nil
+		}
+		return t.Format(field.layout), nil
+	}
+	switch fv.Kind() {
+	case reflect.Struct:
+		object := make(map[string]interface{})
+		err := encodeMergeVarStruct(fv, func(name string, content interface{}) {
+			object[name] = content
+		})
+		return object, err
+	case reflect.Slice, reflect.Array:
+		if fv.Kind() == reflect.Slice && fv.IsNil() {
+			return []interface{}{}, nil
+		}
+		items := make([]interface{}, fv.Len())
+		for i := range items {
+			item, err := encodeMergeVarValue(fv.Index(i), mergeVarField{layout: field.layout})
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case reflect.Map:
+		if fv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys must be strings, not %s", fv.Type().Key())
+		}
+		object := make(map[string]interface{}, fv.Len())
+		for _, key := range fv.MapKeys() {
+			item, err := encodeMergeVarValue(fv.MapIndex(key), mergeVarField{layout: field.layout})
+			if err != nil {
+				return nil, err
+			}
+			object[key.String()] = item
+		}
+		return object, nil
+	case reflect.Func, reflect.Chan, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return nil, fmt.Errorf("%s cannot be used as a merge var", fv.Type())
+	}
+	return fv.Interface(), nil
+}
+
+func isEmptyMergeVar(fv reflect.Value) bool {
+	if fv.Type() == timeType {
+		return fv.Interface().(time.Time).IsZero()
+	}
+	switch fv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return fv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return fv.IsNil()
+	}
+	return fv.IsZero()
+}
+
+// DecodeMergeVars reads merge values, such as MemberInfo.Merges, back into the
+// struct pointed to by v, following the tags described in EncodeMergeVars.
+// Names are matched case insensitively and numbers MailChimp returns as
+// strings are converted. Nil pointers to flattened structs are allocated when
+// one of their fields is found, except embedded pointers to unexported types.
+func DecodeMergeVars(merges map[string]interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("merge vars can only be decoded into a pointer to a struct")
+	}
+	_, err := decodeMergeVarStruct(merges, rv.Elem())
+	return err
+}
+
+// decodeMergeVarStruct fills the fields of rv found in merges, and reports
+// whether there was any.
+func decodeMergeVarStruct(merges map[string]interface{}, rv reflect.Value) (bool, error) {
+	decoded := false
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		fv := rv.Field(i)
+		tag, tagged := sf.Tag.Lookup("mergevar")
+		if tag == "-" || (sf.PkgPath != "" && (!sf.Anonymous || tagged)) {
+			continue
+		}
+		if !tagged {
+			found, err := decodeFlattenedMergeVars(merges, fv)
+			if err != nil {
+				return decoded, err
+			}
+			decoded = decoded || found
+			continue
+		}
+		field := parseMergeVarTag(tag)
+		value, found := lookupMergeVar(merges, field.name)
+		if !found || value == nil {
+			continue
+		}
+		if err := decodeMergeVarValue(value, fv, field); err != nil {
+			return decoded, fmt.Errorf("%s: %v", field.name, err)
+		}
+		decoded = true
+	}
+	return decoded, nil
+}
+
+// decodeFlattenedMergeVars fills an untagged struct field, flattened into its
+// parent by the encoder. A nil pointer to a struct is only allocated when
+// merges hold one of its fields, so that it stays nil as it was encoded.
+func decodeFlattenedMergeVars(merges map[string]interface{}, fv reflect.Value) (bool, error) {
+	switch {
+	case fv.Kind() == reflect.Struct && fv.Type() != timeType:
+		return decodeMergeVarStruct(merges, fv)
+	case fv.Kind() != reflect.Ptr:
+		return false, nil
+	case !fv.IsNil():
+		return decodeFlattenedMergeVars(merges, fv.Elem())
+	case !fv.CanSet():
+		// an embedded pointer to an unexported struct cannot be allocated
+		return false, nil
+	}
+	ptr := reflect.New(fv.Type().Elem())
+	found, err := decodeFlattenedMergeVars(merges, ptr.Elem())
+	if found && err == nil {
+		fv.Set(ptr)
+	}
+	return found, err
+}
+
+func lookupMergeVar(merges map[string]interface{}, name string) (interface{}, bool) {
+	if value, found := merges[name]; found {
+		return value, true
+	}
+	for key, value := range merges {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func decodeMergeVarValue(value interface{}, fv reflect.Value, field mergeVarField) error {
+	if fv.Kind() == reflect.Ptr {
+		ptr := reflect.New(fv.Type().Elem())
+		if err := decodeMergeVarValue(value, ptr.Elem(), field); err != nil {
+			return err
+		}
+		fv.Set(ptr)
+		return nil
+	}
+	if fv.Type() == timeType {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("cannot decode %T into a time", value)
+		}
+		if s == "" {
+			return nil
+		}
+		t, err := time.Parse(field.layout, s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		switch s := value.(type) {
+		case string:
+			fv.SetString(s)
+		case float64:
+			fv.SetString(strconv.FormatFloat(s, 'f', -1, 64))
+		default:
+			fv.SetString(fmt.Sprint(value))
+		}
+	case reflect.Bool:
+		switch b := value.(type) {
+		case bool:
+			fv.SetBool(b)
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil && b != "" {
+				return err
+			}
+			fv.SetBool(parsed)
+		default:
+			return fmt.Errorf("cannot decode %T into a bool", value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := mergeVarNumber(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := mergeVarNumber(value)
+		if err != nil {
+			return err
+		}
+		fv.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		n, err := mergeVarNumber(value)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot decode %T into a struct", value)
+		}
+		_, err := decodeMergeVarStruct(object, fv)
+		return err
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("cannot decode %T into a slice", value)
+		}
+		slice := reflect.MakeSlice(fv.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeMergeVarValue(item, slice.Index(i), mergeVarField{layout: field.layout}); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+	case reflect.Interface:
+		fv.Set(reflect.ValueOf(value))
+	default:
+		// fall back on encoding/json for maps and anything else
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, fv.Addr().Interface())
+	}
+	return nil
+}
+
+func mergeVarNumber(value interface{}) (float64, error) {
+	switch n := value.(type) {
+	case float64:
+		return n, nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		if n == "" {
+			return 0, nil
+		}
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	}
+	return 0, fmt.Errorf("cannot decode %T into a number", value)
+}
@@ -0,0 +1,144 @@
+package gochimp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type mergeVarAddress struct {
+	Street string `mergevar:"addr1"`
+	City   string `mergevar:"city"`
+}
+
+type mergeVarItem struct {
+	Title string  `mergevar:"title"`
+	Price float64 `mergevar:"price"`
+}
+
+type mergeVarAudit struct {
+	Source string `mergevar:"SOURCE,omitempty"`
+}
+
+type mergeVarSubscriber struct {
+	mergeVarAudit
+	FirstName string          `mergevar:"FNAME"`
+	LastName  string          `mergevar:"LNAME,omitempty"`
+	Age       int             `mergevar:"AGE"`
+	Birthday  time.Time       `mergevar:"BIRTHDAY,time=Jan 2, 2006"`
+	Signup    time.Time       `mergevar:"SIGNUP,omitempty"`
+	Address   mergeVarAddress `mergevar:"ADDRESS"`
+	Items     []mergeVarItem  `mergevar:"items"`
+	Nickname  *string         `mergevar:"NICK,omitempty"`
+	Internal  string          `mergevar:"-"`
+	Untagged  string
+}
+
+func TestEncodeMergeVars(t *testing.T) {
+	s := mergeVarSubscriber{
+		mergeVarAudit: mergeVarAudit{Source: "api"},
+		FirstName:     "Ann",
+		Age:           42,
+		Birthday:      time.Date(1980, 3, 4, 0, 0, 0, 0, time.UTC),
+		Address:       mergeVarAddress{Street: "1 Main St", City: "Springfield"},
+		Items:         []mergeVarItem{{Title: "Book", Price: 9.5}},
+		Internal:      "secret",
+		Untagged:      "ignored",
+	}
+	vars, err := EncodeMergeVars(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Var{
+		{Name: "SOURCE", Content: "api"},
+		{Name: "FNAME", Content: "Ann"},
+		{Name: "AGE", Content: 42},
+		{Name: "BIRTHDAY", Content: "Mar 4, 1980"},
+		{Name: "ADDRESS", Content: map[string]interface{}{"addr1": "1 Main St", "city": "Springfield"}},
+		{Name: "items", Content: []interface{}{map[string]interface{}{"title": "Book", "price": 9.5}}},
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("wrong merge vars\nexpected %#v\ngot      %#v", expected, vars)
+	}
+
+	var message Message
+	if err := message.AddMergeVarsFrom("ann@example.com", s); err != nil {
+		t.Fatal(err)
+	}
+	if message.MergeVars[0].Recipient != "ann@example.com" || len(message.MergeVars[0].Vars) != len(expected) {
+		t.Errorf("wrong recipient merge vars %+v", message.MergeVars)
+	}
+	if _, err := EncodeMergeVars("not a struct"); err == nil {
+		t.Error("expected an error encoding a string")
+	}
+}
+
+func TestDecodeMergeVars(t *testing.T) {
+	s := mergeVarSubscriber{
+		FirstName:     "Ann",
+		LastName:      "Smith",
+		Age:           42,
+		Birthday:      time.Date(1980, 3, 4, 0, 0, 0, 0, time.UTC),
+		Address:       mergeVarAddress{Street: "1 Main St", City: "Springfield"},
+		Items:         []mergeVarItem{},
+		Nickname:      StringPtr("annie"),
+		mergeVarAudit: mergeVarAudit{Source: "import"},
+	}
+	merges, err := EncodeChimpMergeVars(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// round trip through JSON the way MemberInfo.Merges is filled, with the
+	// age returned as a string and a lower cased name
+	b, _ := json.Marshal(merges)
+	var info MemberInfo
+	if err := json.Unmarshal([]byte(`{"merges":`+string(b)+`}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	info.Merges["AGE"] = "42"
+	info.Merges["fname"] = info.Merges["FNAME"]
+	delete(info.Merges, "FNAME")
+
+	var decoded mergeVarSubscriber
+	if err := DecodeMergeVars(info.Merges, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, s) {
+		t.Errorf("round trip failed\nexpected %+v\ngot      %+v", s, decoded)
+	}
+}
+
+type MergeVarCompany struct {
+	Company string `mergevar:"COMPANY"`
+	Size    int    `mergevar:"SIZE,omitempty"`
+}
+
+type mergeVarLead struct {
+	*MergeVarCompany
+	*mergeVarAudit
+	Email string `mergevar:"EMAIL"`
+}
+
+func TestDecodeMergeVarsEmbeddedPointers(t *testing.T) {
+	lead := mergeVarLead{MergeVarCompany: &MergeVarCompany{Company: "Acme", Size: 12}, Email: "ann@example.com"}
+	merges, err := EncodeChimpMergeVars(lead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded mergeVarLead
+	if err := DecodeMergeVars(merges, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, lead) {
+		t.Errorf("round trip failed\nexpected %+v\ngot      %+v", lead, decoded)
+	}
+
+	// a nil embedded pointer stays nil when none of its fields is there
+	lead.MergeVarCompany = nil
+	merges, _ = EncodeChimpMergeVars(lead)
+	decoded = mergeVarLead{}
+	if err := DecodeMergeVars(merges, &decoded); err != nil || decoded.MergeVarCompany != nil || decoded.Email != lead.Email {
+		t.Errorf("got %+v %v", decoded, err)
+	}
+}
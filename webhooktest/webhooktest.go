@@ -0,0 +1,267 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhooktest builds Mandrill webhook batches and MailChimp list
+// webhook posts, so that webhook consumers can be tested under go test.
+package webhooktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattbaird/gochimp"
+)
+
+// MandrillEvents are all the Mandrill webhook event types.
+var MandrillEvents = []string{
+	gochimp.WebhookEventSend, gochimp.WebhookEventDeferral, gochimp.WebhookEventHardBounce,
+	gochimp.WebhookEventSoftBounce, gochimp.WebhookEventOpen, gochimp.WebhookEventClick,
+	gochimp.WebhookEventSpam, gochimp.WebhookEventUnsub, gochimp.WebhookEventReject,
+	gochimp.WebhookEventInbound, gochimp.WebhookEventWhitelist, gochimp.WebhookEventBlacklist,
+}
+
+// ChimpEvents are all the MailChimp list webhook event types.
+var ChimpEvents = []string{
+	gochimp.ChimpWebhookSubscribe, gochimp.ChimpWebhookUnsubscribe, gochimp.ChimpWebhookProfile,
+	gochimp.ChimpWebhookCleaned, gochimp.ChimpWebhookUpemail, gochimp.ChimpWebhookCampaign,
+}
+
+// Event is a Mandrill webhook event, as it is posted.
+type Event map[string]interface{}
+
+// Generator builds events with plausible payloads. Ids are numbered, so a
+// generator built with the same fields always builds the same events. It is
+// not safe for concurrent use.
+type Generator struct {
+	// Now is the time of the events, each event is a second after the previous one
+	Now    time.Time
+	Email  string
+	Sender string
+	ListId string
+
+	seq int
+}
+
+// NewGenerator returns a generator for events sent to recipient@example.com
+// by sender@example.org.
+func NewGenerator() *Generator {
+	return &Generator{
+		Now:    time.Date(2013, 4, 4, 21, 31, 51, 0, time.UTC),
+		Email:  "recipient@example.com",
+		Sender: "sender@example.org",
+		ListId: "a6b5da1054",
+	}
+}
+
+func (g *Generator) next() (int, int64) {
+	g.seq++
+	return g.seq, g.Now.Add(time.Duration(g.seq) * time.Second).Unix()
+}
+
+// Event builds an event of a type, one of MandrillEvents.
+func (g *Generator) Event(eventType string) Event {
+	seq, ts := g.next()
+	id := fmt.Sprintf("%032x", seq)
+	switch eventType {
+	case gochimp.WebhookEventWhitelist:
+		return Event{"type": eventType, "action": "add", "ts": ts,
+			"entry": map[string]interface{}{"email": g.Email, "detail": "whitelisted: replied to a message", "created_at": apiTime(ts)}}
+	case gochimp.WebhookEventBlacklist:
+		return Event{"type": eventType, "action": "add", "ts": ts,
+			"reject": map[string]interface{}{"email": g.Email, "reason": "hard-bounce", "detail": "smtp;550 5.1.1 user unknown",
+				"created_at": apiTime(ts), "last_event_at": apiTime(ts), "expires_at": apiTime(ts + 30*24*3600),
+				"expired": false, "subaccount": nil, "sender": nil}}
+	case gochimp.WebhookEventInbound:
+		return Event{"event": eventType, "ts": ts, "msg": g.inbound(seq)}
+	}
+	msg := map[string]interface{}{
+		"ts": ts - 60, "_id": id, "_version": fmt.Sprintf("v%d", seq), "subject": "Your order has shipped",
+		"email": g.Email, "sender": g.Sender, "tags": []string{"orders"}, "state": "sent",
+		"metadata": map[string]interface{}{"order_id": fmt.Sprint(1000 + seq)}, "template": "order-shipped",
+		"opens": []interface{}{}, "clicks": []interface{}{}, "resends": []interface{}{},
+		"smtp_events": []interface{}{map[string]interface{}{"ts": ts - 59, "type": "sent", "diag": "250 2.0.0 OK",
+			"source_ip": "198.2.128.1", "destination_ip": "203.0.113.25", "size": 10421}},
+	}
+	event := Event{"event": eventType, "ts": ts, "_id": id, "msg": msg}
+	switch eventType {
+	case gochimp.WebhookEventDeferral:
+		msg["state"] = "deferred"
+		msg["smtp_events"] = []interface{}{map[string]interface{}{"ts": ts - 59, "type": "deferred",
+			"diag": "451 4.3.5 Server busy, try again later", "source_ip": "198.2.128.1", "destination_ip": "203.0.113.25", "size": 0}}
+	case gochimp.WebhookEventHardBounce:
+		msg["state"] = "bounced"
+		msg["diag"] = "smtp;550 5.1.1 The email account that you tried to reach does not exist."
+		msg["bounce_description"] = "bad_mailbox"
+		msg["smtp_events"] = []interface{}{}
+	case gochimp.WebhookEventSoftBounce:
+		msg["state"] = "soft-bounced"
+		msg["diag"] = "smtp;552 5.2.2 Mailbox full"
+		msg["bounce_description"] = "mailbox_full"
+	case gochimp.WebhookEventReject:
+		msg["state"] = "rejected"
+		msg["smtp_events"] = []interface{}{}
+	case gochimp.WebhookEventOpen, gochimp.WebhookEventClick:
+		activity := map[string]interface{}{"ts": ts, "ip": "203.0.113.7", "location": "Atlanta, GA, United States",
+			"ua": "Mac OS X/Safari"}
+		msg["opens"] = []interface{}{activity}
+		event["ip"] = "203.0.113.7"
+		event["user_agent"] = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_3) AppleWebKit/536.29.13 (KHTML, like Gecko) Version/6.0.4 Safari/536.29.13"
+		event["user_agent_parsed"] = map[string]interface{}{"type": "Browser", "ua_family": "Safari", "ua_name": "Safari 6.0.4",
+			"ua_version": "6.0.4", "ua_company": "Apple Inc.", "os_family": "OS X", "os_name": "OS X 10.8 Mountain Lion",
+			"os_company": "Apple Computer, Inc.", "mobile": false}
+		event["location"] = map[string]interface{}{"country_short": "US", "country": "United States", "region": "Georgia",
+			"city": "Atlanta", "postal_code": "30318", "timezone": "-04:00", "latitude": 33.7857, "longitude": -84.4033}
+		if eventType == gochimp.WebhookEventClick {
+			link := "https://example.org/orders/" + fmt.Sprint(1000+seq)
+			event["url"] = link
+			click := map[string]interface{}{"ts": ts, "url": link}
+			msg["clicks"] = []interface{}{click}
+		}
+	}
+	return event
+}
+
+func (g *Generator) inbound(seq int) map[string]interface{} {
+	to := fmt.Sprintf("support+%d@inbound.example.com", seq)
+	raw := fmt.Sprintf("Received: from mail.example.net\nFrom: Ann Example <ann@example.net>\nTo: %s\n"+
+		"Subject: Question about order %d\nMessage-Id: <%d@example.net>\nContent-Type: text/plain; charset=utf-8\n\n"+
+		"Where is my order?\n", to, 1000+seq, seq)
+	return map[string]interface{}{
+		"raw_msg": raw,
+		"headers": map[string]interface{}{"Received": []string{"from mail.example.net"}, "From": "Ann Example <ann@example.net>",
+			"To": to, "Subject": fmt.Sprintf("Question about order %d", 1000+seq), "Message-Id": fmt.Sprintf("<%d@example.net>", seq),
+			"Content-Type": "text/plain; charset=utf-8"},
+		"text": "Where is my order?\n", "html": "", "from_email": "ann@example.net", "from_name": "Ann Example",
+		"to": [][]string{{to, ""}}, "email": to, "subject": fmt.Sprintf("Question about order %d", 1000+seq),
+		"tags": []string{}, "sender": nil,
+		"attachments": map[string]interface{}{"receipt.pdf": map[string]interface{}{"name": "receipt.pdf",
+			"type": "application/pdf", "content": "JVBERi0xLjQ=", "base64": true}},
+		"spam_report": map[string]interface{}{"score": 0.4, "matched_rules": []interface{}{
+			map[string]interface{}{"name": "RCVD_IN_DNSWL_LOW", "score": -0.7, "description": "RBL: Sender listed at http://www.dnswl.org/, low trust"},
+		}},
+		"dkim": map[string]interface{}{"signed": true, "valid": true},
+		"spf":  map[string]interface{}{"result": "pass", "detail": "sender SPF authorized"},
+	}
+}
+
+// Batch builds a batch with an event of each type, in order.
+func (g *Generator) Batch(eventTypes ...string) string {
+	events := make([]Event, len(eventTypes))
+	for i, eventType := range eventTypes {
+		events[i] = g.Event(eventType)
+	}
+	return Batch(events...)
+}
+
+// Batch encodes events as the mandrill_events field of a post.
+func Batch(events ...Event) string {
+	if events == nil {
+		events = []Event{}
+	}
+	b, err := json.Marshal(events)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+// NewRequest returns a webhook post of batch to webhookURL, signed with
+// authKey as Mandrill signs it. A blank authKey leaves it unsigned.
+func NewRequest(authKey string, webhookURL string, batch string) *http.Request {
+	form := url.Values{"mandrill_events": {batch}}
+	r := httptest.NewRequest("POST", webhookURL, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if authKey != "" {
+		r.Header.Set(gochimp.MandrillSignatureHeader, gochimp.WebhookSignature(authKey, webhookURL, form))
+	}
+	return r
+}
+
+// Post posts a signed batch to h and returns its response.
+func Post(h http.Handler, authKey string, webhookURL string, batch string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, NewRequest(authKey, webhookURL, batch))
+	return w
+}
+
+// ChimpEvent builds the form of a list webhook post of a type, one of ChimpEvents.
+func (g *Generator) ChimpEvent(eventType string) url.Values {
+	seq, ts := g.next()
+	form := url.Values{}
+	form.Set("type", eventType)
+	form.Set("fired_at", apiTime(ts))
+	data := func(key string, value string) {
+		form.Set("data["+strings.Replace(key, ".", "][", -1)+"]", value)
+	}
+	data("list_id", g.ListId)
+	switch eventType {
+	case gochimp.ChimpWebhookUpemail:
+		data("new_id", fmt.Sprintf("%010x", seq+1))
+		data("new_email", "new."+g.Email)
+		data("old_email", g.Email)
+		return form
+	case gochimp.ChimpWebhookCleaned:
+		data("campaign_id", "4fjk2ma9xd")
+		data("reason", "hard")
+		data("email", g.Email)
+		return form
+	case gochimp.ChimpWebhookCampaign:
+		data("id", "5aa2102003")
+		data("subject", "Spring newsletter")
+		data("status", "sent")
+		data("reason", "")
+		return form
+	}
+	data("id", fmt.Sprintf("%010x", seq))
+	data("email", g.Email)
+	data("email_type", "html")
+	data("ip_opt", "203.0.113.7")
+	data("merges.EMAIL", g.Email)
+	data("merges.FNAME", "Ann")
+	data("merges.LNAME", "Example")
+	data("merges.INTERESTS", "Product news, Events")
+	data("merges.GROUPINGS.0.id", "1")
+	data("merges.GROUPINGS.0.name", "Interests")
+	data("merges.GROUPINGS.0.groups", "Product news, Events")
+	switch eventType {
+	case gochimp.ChimpWebhookSubscribe:
+		data("ip_signup", "203.0.113.7")
+	case gochimp.ChimpWebhookUnsubscribe:
+		data("action", "unsub")
+		data("reason", "manual")
+		data("campaign_id", "4fjk2ma9xd")
+	}
+	return form
+}
+
+// NewChimpRequest returns a list webhook post of form to webhookURL, which
+// carries the secret of the handler, see gochimp.ChimpWebhookURL.
+func NewChimpRequest(webhookURL string, form url.Values) *http.Request {
+	r := httptest.NewRequest("POST", webhookURL, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// PostChimp posts form to h and returns its response.
+func PostChimp(h http.Handler, webhookURL string, form url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, NewChimpRequest(webhookURL, form))
+	return w
+}
+
+func apiTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(gochimp.APITimeFormat)
+}
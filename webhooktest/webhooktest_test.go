@@ -0,0 +1,79 @@
+// Copyright 2013 Matthew Baird
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhooktest
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/mattbaird/gochimp"
+)
+
+func TestMandrillBatch(t *testing.T) {
+	const webhookURL = "https://example.com/mandrill"
+	g := NewGenerator()
+	batch := g.Batch(MandrillEvents...)
+	h := gochimp.NewWebhookHandler("key", webhookURL)
+	var mu sync.Mutex
+	seen := make(map[string]gochimp.WebhookEvent)
+	h.OnAny(func(event gochimp.WebhookEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[event.Event] = event
+		return nil
+	})
+	if w := Post(h, "key", webhookURL, batch); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if len(seen) != len(MandrillEvents) {
+		t.Errorf("got %d event types", len(seen))
+	}
+	if bounce := seen[gochimp.WebhookEventHardBounce]; bounce.Msg == nil || bounce.Msg.State != "bounced" || bounce.Msg.Email != g.Email {
+		t.Errorf("wrong bounce %+v", bounce.Msg)
+	}
+	if click := seen[gochimp.WebhookEventClick]; click.Url == "" || click.Location == nil || len(click.Msg.Clicks) != 1 {
+		t.Errorf("wrong click %+v", click)
+	}
+	if inbound := seen[gochimp.WebhookEventInbound]; inbound.Inbound == nil || string(inbound.Inbound.Attachments["receipt.pdf"].Data) != "%PDF-1.4" {
+		t.Errorf("wrong inbound %+v", inbound.Inbound)
+	}
+	if blacklist := seen[gochimp.WebhookEventBlacklist]; !blacklist.IsSync() || blacklist.Reject.CreatedAt.IsZero() {
+		t.Errorf("wrong blacklist %+v", blacklist)
+	}
+	if w := Post(h, "other", webhookURL, batch); w.Code != http.StatusForbidden {
+		t.Errorf("wrong key got status %d", w.Code)
+	}
+}
+
+func TestChimpEvents(t *testing.T) {
+	g := NewGenerator()
+	webhookURL, _ := gochimp.ChimpWebhookURL("https://example.com/mailchimp", "", "s3cret")
+	h := gochimp.NewChimpWebhookHandler("s3cret")
+	seen := make(map[string]gochimp.ChimpWebhookEvent)
+	h.OnAny(func(event gochimp.ChimpWebhookEvent) error {
+		seen[event.Type] = event
+		return nil
+	})
+	for _, eventType := range ChimpEvents {
+		if w := PostChimp(h, webhookURL, g.ChimpEvent(eventType)); w.Code != http.StatusOK {
+			t.Errorf("%s got status %d: %s", eventType, w.Code, w.Body)
+		}
+	}
+	subscribe := seen[gochimp.ChimpWebhookSubscribe]
+	if subscribe.Data.Merge("FNAME") != "Ann" || len(subscribe.Data.Groupings()) != 1 || subscribe.FiredAt.IsZero() {
+		t.Errorf("wrong subscribe %+v", subscribe)
+	}
+	if upemail := seen[gochimp.ChimpWebhookUpemail]; upemail.Data.OldEmail != g.Email || upemail.Data.ListId != g.ListId {
+		t.Errorf("wrong upemail %+v", upemail.Data)
+	}
+}